@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+	"warehouse-system/pkg/queries"
+)
+
+// invalidationChannel is notified by triggers on tables reports are computed from,
+// the payload is the table name and the tenant of changed rows separated by a colon,
+// or the table name only when rows of all tenants have changed.
+const invalidationChannel = "cache_invalidation"
+
+// Delays between attempts to start listening for cache invalidations.
+const (
+	minListenRetryDelay = time.Second
+	maxListenRetryDelay = time.Minute
+)
+
+// listenInvalidations invalidates caches of reports when tables they depend on change.
+func (handler *QueueHandler) listenInvalidations(ctx context.Context) {
+	payloads := make(chan string, 100)
+	go handler.invalidateCaches(ctx, payloads)
+
+	// the listener re-establishes a lost connection itself, but the initial LISTEN is retried here
+	retryDelay := minListenRetryDelay
+	for {
+		err := handler.postgresClient.Listen(ctx, invalidationChannel, func(payload string) {
+			select {
+			case payloads <- payload:
+			case <-ctx.Done():
+			}
+		})
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+		handler.log.Printf("Unable to listen for cache invalidations, retrying in %s: %s\n", retryDelay, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryDelay):
+		}
+		if retryDelay *= 2; retryDelay > maxListenRetryDelay {
+			retryDelay = maxListenRetryDelay
+		}
+	}
+}
+
+// invalidateCaches collects changed tables and their tenants for the debounce interval,
+// so a burst of changes results in a single invalidation. An empty table name invalidates all reports,
+// an empty tenant invalidates reports of all tenants.
+func (handler *QueueHandler) invalidateCaches(ctx context.Context, payloads <-chan string) {
+	debounce := time.Duration(handler.config.InvalidationDebounce) * time.Millisecond
+	changed := make(map[string]map[string]bool)
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case payload := <-payloads:
+			table, tenant := parseInvalidation(payload)
+			if changed[table] == nil {
+				changed[table] = make(map[string]bool)
+			}
+			changed[table][tenant] = true
+			if timer == nil {
+				timer = time.After(debounce)
+			}
+		case <-timer:
+			handler.invalidate(changed)
+			changed = make(map[string]map[string]bool)
+			timer = nil
+		}
+	}
+}
+
+// parseInvalidation splits a notification payload to the table and the tenant.
+func parseInvalidation(payload string) (table, tenant string) {
+	parts := strings.SplitN(payload, ":", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// invalidate deletes caches of parametrized reports depending on the changed tables
+// and recomputes the reports without params, which are served stale meanwhile.
+// Only reports of the tenants whose rows have changed are invalidated.
+func (handler *QueueHandler) invalidate(changed map[string]map[string]bool) {
+	var allTenants []string
+	allTenantsLoaded := false
+	for _, query := range handler.registry.Queries() {
+		tenants := make(map[string]bool)
+		for table, changedTenants := range changed {
+			if table != "" && !query.DependsOn(table) {
+				continue
+			}
+			for tenant := range changedTenants {
+				tenants[tenant] = true
+			}
+		}
+		if len(tenants) == 0 {
+			continue
+		}
+
+		if tenants[""] {
+			// rows of all tenants have changed
+			tenants = map[string]bool{"": true}
+			if !allTenantsLoaded {
+				var err error
+				if allTenants, err = handler.postgresClient.GetTenants(context.Background()); err != nil {
+					// caches with params are deleted anyway, reports without params expire by TTL
+					handler.log.Printf("Unable to get tenants: %s\n", err)
+				}
+				allTenantsLoaded = true
+			}
+		}
+
+		for tenant := range tenants {
+			count, err := handler.redisClient.DeleteCaches(query.ParamsCachePattern(tenant))
+			if err != nil {
+				handler.log.Printf("Unable to delete %s caches: %s\n", query.Name, err)
+			} else if count > 0 {
+				handler.log.Printf("%d %s caches are deleted.\n", count, query.Name)
+			}
+
+			refreshed := []string{tenant}
+			if tenant == "" {
+				refreshed = allTenants
+			}
+			for _, tenant := range refreshed {
+				// a refresh requested before the change would compute outdated data
+				cacheKey := query.CacheKey(tenant, queries.Params{})
+				if err := handler.redisClient.ClearRefreshing(cacheKey); err != nil {
+					handler.log.Printf("Unable to clear %s refresh mark: %s\n", cacheKey, err)
+				}
+				handler.scheduleTenantRefresh(query, tenant)
+			}
+		}
+	}
+}
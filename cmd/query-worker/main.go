@@ -4,8 +4,11 @@ import (
 	"context"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 	"warehouse-system/config"
 	"warehouse-system/pkg/postgres"
+	"warehouse-system/pkg/queries"
 	"warehouse-system/pkg/redis"
 )
 
@@ -20,7 +23,7 @@ func main() {
 
 	appConfig := config.NewAppConfig()
 	if err := appConfig.Load(path, file); err != nil {
-		logger.Printf("unable to load app config from %s/%s\n", path, file)
+		logger.Printf("unable to load app config from %s/%s: %s\n", path, file, err)
 		return
 	}
 
@@ -38,6 +41,17 @@ func main() {
 	}
 	defer postgresClient.Close()
 
-	queueHandler := NewQueueHandler(logger, appConfig, redisClient, postgresClient)
-	queueHandler.Run()
+	registry := queries.NewReportsRegistry(appConfig)
+
+	queueHandler := NewQueueHandler(logger, appConfig, redisClient, postgresClient, registry)
+	if queueHandler == nil {
+		logger.Println("Unable to create new queue handler.")
+		return
+	}
+
+	shutdownCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	queueHandler.Run(shutdownCtx)
+	logger.Println("Query worker stopped.")
 }
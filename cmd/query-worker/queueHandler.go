@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"github.com/robfig/cron/v3"
 	"log"
-	"strings"
+	"sync"
 	"time"
 	"warehouse-system/config"
 	"warehouse-system/pkg/postgres"
+	"warehouse-system/pkg/queries"
+	"warehouse-system/pkg/queue"
+	"warehouse-system/pkg/ratelimit"
 	"warehouse-system/pkg/redis"
+	"warehouse-system/pkg/services"
 	"warehouse-system/utils"
 )
 
@@ -16,138 +22,208 @@ type QueueHandler struct {
 	config         *config.AppConfig
 	redisClient    *redis.Client
 	postgresClient *postgres.Client
+	registry       *queries.Registry
+	backoff        utils.BackoffStrategy
+	// limiter and leases limit the rate and the concurrency of queries of a token by its plan
+	limiter *ratelimit.Limiter
+	leases  *ratelimit.Leases
+	plans   *services.PlanService
+	// postgresSlots bounds the number of queries running in postgres at the same time
+	postgresSlots chan struct{}
+	workers       []*worker
+	scheduler     *cron.Cron
 }
 
-func (handler *QueueHandler) Run() {
+// Run starts the pool of workers consuming the queue and blocks until ctx is cancelled
+// and the workers have finished. Workers finish requests they are processing; requests
+// still running after the shutdown timeout are cancelled and returned to the queue.
+func (handler *QueueHandler) Run(ctx context.Context) {
+	go handler.promoteDelayedRequests(ctx)
+	go handler.reportStats(ctx)
+	go handler.runScheduler(ctx, handler.scheduler)
+	if handler.config.CacheInvalidation {
+		go handler.listenInvalidations(ctx)
+	}
+	if handler.config.ReliableQueue {
+		// workers drain their requests after ctx is cancelled, they must not look dead meanwhile
+		heartbeatCtx, stopHeartbeat := context.WithCancel(context.Background())
+		defer stopHeartbeat()
+		go handler.heartbeat(heartbeatCtx)
+		go handler.reap(ctx)
+	}
+
+	processCtx, cancelProcessing := context.WithCancel(context.Background())
+	defer cancelProcessing()
+
+	handler.log.Printf("Starting %d workers.\n", len(handler.workers))
+	wg := sync.WaitGroup{}
+	for _, w := range handler.workers {
+		wg.Add(1)
+		go func(w *worker) {
+			defer wg.Done()
+			w.run(ctx, processCtx)
+		}(w)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+	handler.log.Println("Shutting down, waiting for workers to finish their requests.")
+
+	timeout := time.Duration(handler.config.ShutdownTimeout) * time.Second
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		handler.log.Println("Shutdown timeout exceeded, cancelling in-flight requests.")
+		cancelProcessing()
+		<-done
+	}
+	for _, w := range handler.workers {
+		handler.log.Printf("Worker %d stats: %s\n", w.number, w.stats)
+	}
+}
+
+// promoteDelayedRequests moves throttled requests back to the queue when their delay is over.
+func (handler *QueueHandler) promoteDelayedRequests(ctx context.Context) {
+	interval := time.Duration(handler.config.RetryPollInterval) * time.Millisecond
 	for {
-		request, err := handler.redisClient.PopRequestFromQueue()
+		promoted, err := handler.redisClient.PromoteDueRequests(time.Now(), 100)
 		if err != nil {
-			handler.log.Printf("Unable to pop request from queue: %s\n", err)
-			continue
+			handler.log.Printf("Unable to promote delayed requests: %s\n", err)
+		} else if len(promoted) > 0 {
+			handler.log.Printf("%d delayed requests are put to queue.\n", len(promoted))
+			handler.setJobsQueued(promoted)
 		}
-
-		if request == "" {
+		if len(promoted) == 100 {
 			continue
 		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
 
-		parts := strings.Split(request, ":")
-		token := parts[0]
-		uid := parts[1]
-		query := strings.Join(parts[2:], ":")
-		handler.log.Printf("Received: token %s, uid %s, query %s.\n", token, uid, query)
-
-		// get lock counter for token
-		requestCount, err := handler.redisClient.GetLockCounter(token)
+// setJobsQueued moves jobs of the promoted requests from throttled back to queued,
+// so their event streams report queue positions again. A job a worker has already
+// picked up keeps its status.
+func (handler *QueueHandler) setJobsQueued(requests []string) {
+	for _, request := range requests {
+		message, err := queue.Decode(request)
 		if err != nil {
-			handler.log.Printf("Unable to get lock counter for token: %s\n", err)
-			handler.publishResult(token, uid, "internal_err")
+			handler.log.Printf("Unable to decode promoted request: %s\n", err)
 			continue
 		}
-
-		// if counter exceeded, delay and push to queue
-		if requestCount >= handler.config.MaxRequestsCount {
-			handler.log.Printf("Max request count exceeded for token %s.\n", token)
-
-			retryCount, err := handler.redisClient.GetRetryCount(token)
-			if err != nil {
-				handler.log.Printf("Unable to get retry count for token: %s\n", err)
-				handler.publishResult(token, uid, "internal_err")
-				continue
-			}
-			// throw away request if max retry count exceeded
-			if retryCount > handler.config.MaxRetryCount {
-				handler.log.Printf("Max retry count for token: %s\n", err)
-				handler.publishResult(token, uid, "max_retry_count")
-				continue
-			}
-			// delaying with Fibonacci strategy
-			delay := utils.GetFibonacciNumber(retryCount)
-			time.Sleep(time.Duration(delay) * time.Second)
-
-			if err := handler.redisClient.PutRequestToQueue(request); err != nil {
-				handler.log.Printf("Unable to put request to queue: %s\n", err)
-				handler.publishResult(token, uid, "internal_err")
-			} else {
-				handler.log.Println("Request is put to queue successfully.")
-			}
+		id, ok := queue.JobID(message.ReplyTo)
+		if !ok {
 			continue
 		}
-
-		// if counter is ok, do query
-		if requestCount < handler.config.MaxRequestsCount {
-			if err := handler.redisClient.Lock(token); err != nil {
-				handler.log.Printf("Unable to lock request: %s\n", err)
-				handler.publishResult(token, uid, "internal_err")
-				continue
-			}
-
-			var result string
-			switch query {
-			case "products:bought":
-				result = handler.handleGetBoughtProductsQuery()
-			case "items:bought":
-				result = handler.handleGetBoughtItemsQuery()
-			}
-			handler.publishResult(token, uid, result)
-
-			if err := handler.redisClient.Unlock(token); err != nil {
-				handler.log.Printf("Unable to unlock request: %s\n", err)
-				handler.publishResult(token, uid, "internal_err")
-				continue
-			}
+		now := time.Now()
+		updated, err := handler.redisClient.UpdateJobFrom(id, queue.JobThrottled,
+			queue.JobStatusFields(queue.JobQueued, now))
+		if err != nil {
+			handler.log.Printf("Unable to update job %s: %s\n", id, err)
+		} else if updated {
+			event := queue.JobEvent{Event: queue.JobQueued, Status: queue.JobQueued, At: now}
+			handler.publishJobEvent(handler.log, id, event)
 		}
 	}
 }
 
-func (handler *QueueHandler) handleGetBoughtProductsQuery() string {
-	handler.log.Printf("Incoming request: products:bought.")
-	boughtProductsQuantity, err := handler.postgresClient.GetBoughtProductsQuantity()
-	if err != nil {
-		handler.log.Printf("Failed to get bought products quantity from postgres: %s\n", err)
-		return "internal_err"
-	}
-	expireAfter := time.Duration(handler.config.CacheExpireDuration) * time.Second
-	err = handler.redisClient.SetBoughtProductsCache(boughtProductsQuantity, expireAfter)
+// updateJob sets the job fields and publishes the event to subscribers of the job events.
+func (handler *QueueHandler) updateJob(log *log.Logger, id string, fields map[string]interface{},
+	event queue.JobEvent) {
+	updated, err := handler.redisClient.UpdateJob(id, fields)
 	if err != nil {
-		handler.log.Printf("Failed to set bought products cache: %s\n", err)
-		return "internal_err"
+		log.Printf("Unable to update job %s: %s\n", id, err)
+		return
+	} else if !updated {
+		log.Printf("Job %s has expired.\n", id)
+		return
 	}
-	handler.log.Println("BoughtProductsQuantity is got from db successfully.")
-	return "success"
+	handler.publishJobEvent(log, id, event)
 }
 
-func (handler *QueueHandler) handleGetBoughtItemsQuery() string {
-	handler.log.Printf("Incoming request: items:bought.")
-	boughtItemsQuantity, err := handler.postgresClient.GetBoughtItemsQuantity()
+func (handler *QueueHandler) publishJobEvent(log *log.Logger, id string, event queue.JobEvent) {
+	encoded, err := event.Encode()
 	if err != nil {
-		handler.log.Printf("Failed to get bought items quantity from postgres: %s\n", err)
-		return "internal_err"
+		log.Printf("Failed to encode job event: %s\n", err)
+		return
 	}
-	expireAfter := time.Duration(handler.config.CacheExpireDuration) * time.Second
-	err = handler.redisClient.SetBoughtItemsCache(boughtItemsQuantity, expireAfter)
-	if err != nil {
-		handler.log.Printf("Failed to set bought items cache: %s\n", err)
-		return "internal_err"
+	if err := handler.redisClient.PublishResult(queue.JobEventsTopic(id), encoded); err != nil {
+		log.Printf("Failed to publish job %s event: %s\n", id, err)
 	}
-	handler.log.Println("BoughtItemsQuantity is got from db successfully.")
-	return "success"
 }
 
-func (handler *QueueHandler) publishResult(token, uid, result string) {
-	topic := fmt.Sprintf("%s:%s", token, uid)
-	if err := handler.redisClient.PublishResult(topic, result); err != nil {
-		handler.log.Printf("Failed to publish result: %s\n", err)
-	} else {
-		handler.log.Printf("Result '%s' is published successfully to topic %s.\n", result, topic)
+func (handler *QueueHandler) reportStats(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(handler.config.WorkerStatsInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, w := range handler.workers {
+			handler.log.Printf("Worker %d stats: %s\n", w.number, w.stats)
+		}
 	}
 }
 
-func NewQueueHandler(log *log.Logger, config *config.AppConfig, redis *redis.Client, postgres *postgres.Client) *QueueHandler {
+func (handler *QueueHandler) acquirePostgres() {
+	handler.postgresSlots <- struct{}{}
+}
+
+func (handler *QueueHandler) releasePostgres() {
+	<-handler.postgresSlots
+}
+
+func NewQueueHandler(log *log.Logger, config *config.AppConfig, redis *redis.Client, postgres *postgres.Client,
+	registry *queries.Registry) *QueueHandler {
 	log.SetPrefix("[queue handler] ")
-	return &QueueHandler{
+
+	backoff, err := utils.GetBackoffStrategy(config.RetryStrategy)
+	if err != nil {
+		log.Printf("Unable to use retry strategy: %s\n", err)
+		return nil
+	}
+	if config.WorkerCount < 1 || config.PostgresMaxConcurrency < 1 {
+		log.Println("Worker count and postgres max concurrency must be positive.")
+		return nil
+	}
+
+	handler := &QueueHandler{
 		log:            log,
 		config:         config,
 		redisClient:    redis,
 		postgresClient: postgres,
+		registry:       registry,
+		backoff:        backoff,
+		postgresSlots:  make(chan struct{}, config.PostgresMaxConcurrency),
+		leases:         redis.NewLeases(),
+		plans:          services.NewPlanService(log, config, redis),
+	}
+	if handler.limiter, err = redis.NewLimiter(config.RateLimitAlgorithm, ratelimit.Queries); err != nil {
+		log.Printf("Unable to create rate limiter: %s\n", err)
+		return nil
+	}
+
+	if handler.scheduler, err = handler.newScheduler(); err != nil {
+		log.Printf("Unable to create refresh scheduler: %s\n", err)
+		return nil
+	}
+
+	processID := newProcessID()
+	for i := 1; i <= config.WorkerCount; i++ {
+		handler.workers = append(handler.workers, newWorker(handler, i, fmt.Sprintf("%s-%d", processID, i)))
 	}
+	return handler
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+	"warehouse-system/config"
+	"warehouse-system/pkg/queries"
+	"warehouse-system/pkg/queue"
+	"warehouse-system/pkg/redis"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestPromotedJobsAreQueued(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	logger := log.New(ioutil.Discard, "", 0)
+	appConfig := config.NewAppConfig()
+	appConfig.RedisHost = mr.Host()
+	appConfig.RedisPort = mr.Port()
+	redisClient := redis.NewRedisClient(context.Background(), logger, appConfig)
+	if redisClient == nil {
+		t.Fatal("unable to connect to redis")
+	}
+	defer redisClient.Close()
+	handler := NewQueueHandler(logger, appConfig, redisClient, nil, queries.NewRegistry())
+	if handler == nil {
+		t.Fatal("unable to create queue handler")
+	}
+
+	// a throttled job and a job whose promoted request a worker has already picked up
+	now := time.Now()
+	jobs := make(map[string]*queue.Job)
+	for _, status := range []string{queue.JobThrottled, queue.JobRunning} {
+		job := queue.NewJob("token", "tenant-a", "products:bought", queries.Params{})
+		request, err := queue.NewJobMessage(job, now.Add(time.Minute), queue.TraceContext{}).Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		job.Request = request
+		if err := redisClient.CreateJob(job, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := redisClient.UpdateJob(job.ID, queue.JobStatusFields(status, now)); err != nil {
+			t.Fatal(err)
+		}
+		if err := redisClient.ScheduleRequest(request, now.Add(-time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		jobs[status] = job
+	}
+
+	subscription, err := redisClient.SubscribeForResult(queue.JobEventsTopic(jobs[queue.JobThrottled].ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer subscription.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go handler.promoteDelayedRequests(ctx)
+
+	select {
+	case raw := <-subscription.Messages():
+		event, err := queue.DecodeJobEvent(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.Status != queue.JobQueued {
+			t.Fatalf("promoted job event status = %s, want %s", event.Status, queue.JobQueued)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event of the promoted job is published")
+	}
+	cancel()
+
+	for status, want := range map[string]string{queue.JobThrottled: queue.JobQueued, queue.JobRunning: queue.JobRunning} {
+		job, err := redisClient.GetJob(jobs[status].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status != want {
+			t.Errorf("%s job status after promotion = %s, want %s", status, job.Status, want)
+		}
+	}
+	if queued, _ := mr.List("requests"); len(queued) != 2 {
+		t.Errorf("%d requests are promoted, want 2", len(queued))
+	}
+}
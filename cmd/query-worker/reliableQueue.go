@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// popRequest takes the next request from the queue. In reliable queue mode the request
+// is moved to the worker processing list and stays there until it is acknowledged.
+func (w *worker) popRequest() (string, error) {
+	if w.handler.config.ReliableQueue {
+		return w.handler.redisClient.PopRequestToProcessing(w.id, popTimeout)
+	}
+	return w.handler.redisClient.PopRequestFromQueue(popTimeout)
+}
+
+// ackRequest removes the processed request from the worker processing list.
+func (w *worker) ackRequest(request string) {
+	if !w.handler.config.ReliableQueue {
+		return
+	}
+	if err := w.handler.redisClient.AckRequest(w.id, request); err != nil {
+		w.log.Printf("Unable to acknowledge request: %s\n", err)
+	}
+}
+
+// unregister returns requests left in the worker processing list to the queue
+// and removes the worker heartbeat on shutdown.
+func (w *worker) unregister() {
+	if !w.handler.config.ReliableQueue {
+		return
+	}
+	count, err := w.handler.redisClient.RequeueProcessing(w.id)
+	if err != nil {
+		w.log.Printf("Unable to re-queue processing requests: %s\n", err)
+	} else if count > 0 {
+		w.log.Printf("%d processing requests are re-queued.\n", count)
+	}
+	if err := w.handler.redisClient.RemoveHeartbeat(w.id); err != nil {
+		w.log.Printf("Unable to remove heartbeat: %s\n", err)
+	}
+}
+
+// heartbeat periodically reports the workers are alive until ctx is cancelled when the pool
+// has exited. A worker that stops heartbeating is considered dead and its in-flight requests
+// are re-queued by reapers of other workers.
+func (handler *QueueHandler) heartbeat(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(handler.config.WorkerHeartbeatInterval) * time.Second)
+	defer ticker.Stop()
+	timeout := time.Duration(handler.config.WorkerHeartbeatTimeout) * time.Second
+	for {
+		for _, w := range handler.workers {
+			if err := handler.redisClient.Heartbeat(w.id, timeout); err != nil {
+				handler.log.Printf("Unable to send heartbeat of worker %d: %s\n", w.number, err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (handler *QueueHandler) reap(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(handler.config.WorkerHeartbeatInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		workers, err := handler.redisClient.GetWorkers()
+		if err != nil {
+			handler.log.Printf("Unable to get workers: %s\n", err)
+			continue
+		}
+		for _, workerID := range workers {
+			alive, err := handler.redisClient.IsWorkerAlive(workerID)
+			if err != nil {
+				handler.log.Printf("Unable to check worker %s heartbeat: %s\n", workerID, err)
+				continue
+			}
+			if alive {
+				continue
+			}
+			count, err := handler.redisClient.RequeueProcessing(workerID)
+			if err != nil {
+				handler.log.Printf("Unable to re-queue requests of worker %s: %s\n", workerID, err)
+				continue
+			}
+			handler.log.Printf("Worker %s is dead, %d requests are re-queued.\n", workerID, count)
+		}
+	}
+}
+
+func newProcessID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
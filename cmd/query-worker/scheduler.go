@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"strings"
+	"time"
+	"warehouse-system/pkg/queries"
+	"warehouse-system/pkg/queue"
+)
+
+// parseRefreshSchedule parses "products:bought=*/5 * * * *;items:bought=@every 10m"
+// into cron expressions by query name.
+func parseRefreshSchedule(schedule string) (map[string]string, error) {
+	specs := make(map[string]string)
+	for _, entry := range strings.Split(schedule, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid refresh schedule entry %q", entry)
+		}
+		specs[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return specs, nil
+}
+
+// newScheduler creates cron jobs that refresh report caches before they expire.
+func (handler *QueueHandler) newScheduler() (*cron.Cron, error) {
+	specs, err := parseRefreshSchedule(handler.config.RefreshSchedule)
+	if err != nil {
+		return nil, err
+	}
+
+	scheduler := cron.New()
+	for name, spec := range specs {
+		query, ok := handler.registry.Get(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown query %s in refresh schedule", name)
+		}
+		if _, err := scheduler.AddFunc(spec, func() { handler.scheduleRefresh(query) }); err != nil {
+			return nil, fmt.Errorf("invalid refresh schedule of %s: %s", name, err)
+		}
+		handler.log.Printf("Report %s is refreshed on schedule %q.\n", name, spec)
+	}
+	return scheduler, nil
+}
+
+// runScheduler runs the refresh jobs until ctx is cancelled.
+func (handler *QueueHandler) runScheduler(ctx context.Context, scheduler *cron.Cron) {
+	scheduler.Start()
+	<-ctx.Done()
+	<-scheduler.Stop().Done()
+}
+
+// scheduleRefresh requests refreshes of the report of every tenant.
+func (handler *QueueHandler) scheduleRefresh(query *queries.Query) {
+	tenants, err := handler.postgresClient.GetTenants(context.Background())
+	if err != nil {
+		handler.log.Printf("Unable to get tenants: %s\n", err)
+		return
+	}
+	for _, tenant := range tenants {
+		handler.scheduleTenantRefresh(query, tenant)
+	}
+}
+
+// scheduleTenantRefresh puts a forced refresh request of the report of the tenant with the system
+// token to a queue. Every query worker runs the scheduler, the refresh mark lets only one of them request it.
+func (handler *QueueHandler) scheduleTenantRefresh(query *queries.Query, tenant string) {
+	var params queries.Params
+	cacheKey := query.CacheKey(tenant, params)
+	refreshTimeout := time.Duration(handler.config.InflightTimeout) * time.Second
+	marked, err := handler.redisClient.MarkRefreshing(cacheKey, refreshTimeout)
+	if err != nil {
+		handler.log.Printf("Unable to mark '%s' refreshing: %s\n", cacheKey, err)
+		return
+	}
+	if !marked {
+		return
+	}
+
+	message := queue.NewMessage(handler.config.SystemToken, tenant, queue.NewMessageID(), query.Name, params,
+		time.Now().Add(refreshTimeout), queue.NewTraceContext("", ""))
+	message.Force = true
+	request, err := message.Encode()
+	if err != nil {
+		handler.log.Printf("Unable to encode refresh request: %s\n", err)
+		return
+	}
+	if err := handler.redisClient.PutRequestToQueue(request); err != nil {
+		handler.log.Printf("Unable to put refresh request to queue: %s\n", err)
+		return
+	}
+	handler.log.Printf("Scheduled '%s' refresh request is put to queue.\n", cacheKey)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// workerStats counts request outcomes of a single worker.
+type workerStats struct {
+	mu        sync.Mutex
+	processed int
+	succeeded int
+	failed    int
+	throttled int
+	rejected  int
+	requeued  int
+	coalesced int
+	busy      time.Duration
+}
+
+func (stats *workerStats) record(result string, took time.Duration) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.processed++
+	stats.busy += took
+	switch result {
+	case "success":
+		stats.succeeded++
+	case "throttled":
+		stats.throttled++
+	case "max_retry_count", "unknown_query", "malformed", "expired":
+		stats.rejected++
+	case "requeued":
+		stats.requeued++
+	case "coalesced":
+		stats.coalesced++
+	default:
+		stats.failed++
+	}
+}
+
+func (stats *workerStats) String() string {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	return fmt.Sprintf("processed %d, succeeded %d, failed %d, throttled %d, rejected %d, requeued %d, "+
+		"coalesced %d, busy %s", stats.processed, stats.succeeded, stats.failed, stats.throttled, stats.rejected,
+		stats.requeued, stats.coalesced, stats.busy)
+}
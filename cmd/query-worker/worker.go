@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+	"warehouse-system/pkg/models"
+	"warehouse-system/pkg/queries"
+	"warehouse-system/pkg/queue"
+	"warehouse-system/pkg/ratelimit"
+)
+
+// popTimeout limits blocking on an empty queue, so workers notice shutdown.
+const popTimeout = time.Second
+
+// worker is one of the goroutines of the pool consuming the queue.
+type worker struct {
+	handler *QueueHandler
+	log     *log.Logger
+	number  int
+	// id names the worker processing list in reliable queue mode
+	id    string
+	stats *workerStats
+}
+
+// run consumes the queue until ctx is cancelled. Requests are processed with processCtx,
+// which is cancelled when the shutdown timeout is exceeded.
+func (w *worker) run(ctx, processCtx context.Context) {
+	defer w.unregister()
+	for ctx.Err() == nil {
+		request, err := w.popRequest()
+		if err != nil {
+			w.log.Printf("Unable to pop request from queue: %s\n", err)
+			continue
+		}
+
+		if request == "" {
+			continue
+		}
+
+		started := time.Now()
+		result := w.processRequest(processCtx, request)
+		w.stats.record(result, time.Since(started))
+		w.ackRequest(request)
+	}
+}
+
+// processRequest handles the request and returns its outcome for worker stats.
+func (w *worker) processRequest(ctx context.Context, request string) string {
+	handler := w.handler
+
+	message, err := w.decode(request)
+	if err != nil {
+		// nobody can be notified about a malformed message, keep it for inspection
+		w.log.Printf("Unable to decode request: %s\n", err)
+		return w.fail(request, nil, "malformed", err.Error())
+	}
+	token := message.Token
+	w.log.Printf("Received: id %s, token %s, tenant %s, query %s, attempt %d, trace %s.\n",
+		message.ID, token, message.Tenant, message.Query, message.Attempt, message.Trace.TraceParent)
+
+	if message.Expired(time.Now()) {
+		w.log.Printf("Request %s deadline is exceeded, skipping.\n", message.ID)
+		return "expired"
+	}
+
+	registeredQuery, ok := handler.registry.Get(message.Query)
+	if !ok {
+		w.log.Printf("Unknown query %s.\n", message.Query)
+		w.publishResult(message, queue.Result{Status: "unknown_query"})
+		return "unknown_query"
+	}
+
+	// an identical request may have computed the report while this one was queued
+	cacheKey := registeredQuery.CacheKey(message.Tenant, message.Params)
+	if fields, err := handler.redisClient.GetCache(cacheKey); err != nil {
+		w.log.Printf("Unable to get %s cache: %s\n", cacheKey, err)
+	} else if !message.Force && len(fields) != 0 && !registeredQuery.IsStale(fields, time.Now()) {
+		w.log.Printf("'%s' cache is already computed.\n", cacheKey)
+		payload, err := cachedPayload(registeredQuery, fields)
+		if err != nil {
+			w.log.Printf("Unable to decode %s cache: %s\n", cacheKey, err)
+		} else {
+			w.publishResult(message, queue.Result{Status: "success", Payload: payload})
+			return "coalesced"
+		}
+	}
+
+	// take a concurrency lease and a request of the query rate limit of the token,
+	// they are shared with other workers processing requests of the same token;
+	// the system token of scheduled refreshes isn't limited
+	system := token == handler.config.SystemToken
+	lease := w.id + ":" + message.ID
+	acquired, retryAfter, err := system, time.Duration(0), error(nil)
+	var plan ratelimit.Plan
+	if !system {
+		acquired, retryAfter, plan, err = w.acquire(token, lease)
+	}
+	if err != nil {
+		w.log.Printf("Unable to check request limits: %s\n", err)
+		return w.fail(request, message, "internal_err", "unable to check request limits: "+err.Error())
+	}
+
+	// if a limit is exceeded, delay and push to queue
+	if !acquired {
+		w.log.Printf("Request limit exceeded for token %s.\n", token)
+
+		// throw away request if max retry count exceeded
+		if message.Attempt >= plan.MaxRetryCount {
+			w.log.Printf("Max retry count for request %s.\n", message.ID)
+			return w.fail(request, message, "max_retry_count", "max retry count exceeded")
+		}
+		// delaying with the configured backoff strategy without blocking the worker,
+		// but not before the rate limit allows the request
+		message.Attempt++
+		delay := handler.backoff(message.Attempt)
+		if delay < retryAfter {
+			delay = retryAfter
+		}
+		dueAt := time.Now().Add(delay)
+		message.AddAttempt(w.id, "throttled")
+		retry, err := message.Encode()
+		if err != nil {
+			w.log.Printf("Unable to encode request: %s\n", err)
+			return w.fail(request, message, "internal_err", "unable to encode request: "+err.Error())
+		}
+		if err := handler.redisClient.ScheduleRequest(retry, dueAt); err != nil {
+			w.log.Printf("Unable to schedule request: %s\n", err)
+			return w.fail(request, message, "internal_err", "unable to schedule request: "+err.Error())
+		}
+		w.log.Printf("Request is scheduled to %s.\n", dueAt.Format(time.RFC3339))
+		w.setJobThrottled(message, retry, dueAt)
+		return "throttled"
+	}
+
+	if !system {
+		defer w.release(token, lease)
+	}
+	w.setJobStatus(message, queue.JobRunning)
+
+	// only one worker runs an identical query, others leave their reply topics to it
+	inflightTimeout := time.Duration(handler.config.InflightTimeout) * time.Second
+	leader, err := handler.redisClient.ClaimInflight(cacheKey, w.id, message.ReplyTo, inflightTimeout)
+	if err != nil {
+		w.log.Printf("Unable to claim %s query: %s\n", cacheKey, err)
+		return w.fail(request, message, "internal_err", "unable to claim query: "+err.Error())
+	}
+	if !leader {
+		w.log.Printf("'%s' query is already running, waiting for its result.\n", cacheKey)
+		return "coalesced"
+	}
+
+	payload, err := w.handleQuery(ctx, registeredQuery, message.Tenant, message.Params)
+	if ctx.Err() != nil {
+		// shutting down, leave the request for another worker; waiters are kept
+		// and get the result from the worker that runs the request next
+		if err := handler.redisClient.ReleaseInflight(cacheKey); err != nil {
+			w.log.Printf("Unable to release %s query: %s\n", cacheKey, err)
+		}
+		if err := handler.redisClient.ReturnRequestToQueue(request); err != nil {
+			w.log.Printf("Unable to return request to queue: %s\n", err)
+			return w.fail(request, message, "internal_err", "unable to return request to queue: "+err.Error())
+		}
+		w.log.Println("Request is returned to queue.")
+		w.setJobStatus(message, queue.JobQueued)
+		return "requeued"
+	}
+
+	result := queue.Result{Status: "success", Payload: payload}
+	if err != nil {
+		result = queue.Result{Status: "internal_err"}
+	}
+	waiters, finishErr := handler.redisClient.FinishInflight(cacheKey)
+	if finishErr != nil {
+		w.log.Printf("Unable to finish %s query: %s\n", cacheKey, finishErr)
+	}
+	for _, topic := range waiters {
+		if topic != message.ReplyTo {
+			w.publish(topic, result)
+		}
+	}
+
+	if err != nil {
+		return w.fail(request, message, result.Status, err.Error())
+	}
+	w.publishResult(message, result)
+	return result.Status
+}
+
+// decode parses the request, legacy messages are accepted until LEGACY_MESSAGES_UNTIL.
+func (w *worker) decode(request string) (*queue.Message, error) {
+	if queue.IsLegacy(request) && w.handler.config.LegacyMessagesAccepted(time.Now()) {
+		return queue.DecodeLegacy(request)
+	}
+	return queue.Decode(request)
+}
+
+// acquire takes a concurrency lease of the token and a request of its query rate limit,
+// the limits are read from the plan of the token. If either limit is exceeded, it returns
+// false and the time to wait before a retry.
+func (w *worker) acquire(token, lease string) (bool, time.Duration, ratelimit.Plan, error) {
+	handler := w.handler
+	plan, err := handler.plans.GetPlan(token)
+	if err != nil {
+		return false, 0, plan, err
+	}
+
+	leaseTimeout := time.Duration(handler.config.InflightTimeout) * time.Second
+	acquired, err := handler.leases.Acquire(token, lease, plan.Concurrency, leaseTimeout)
+	if err != nil || !acquired {
+		return false, 0, plan, err
+	}
+
+	decision, err := handler.limiter.Allow(token, plan.QueryLimit())
+	if err != nil || !decision.Allowed {
+		w.release(token, lease)
+		return false, decision.RetryAfter, plan, err
+	}
+	return true, 0, plan, nil
+}
+
+func (w *worker) release(token, lease string) {
+	if err := w.handler.leases.Release(token, lease); err != nil {
+		w.log.Printf("Unable to release lease: %s\n", err)
+	}
+}
+
+// fail publishes the failure result and keeps the request in the dead letter store.
+// The message is nil if the request couldn't be decoded.
+func (w *worker) fail(request string, message *queue.Message, result, reason string) string {
+	if message != nil {
+		w.publishResult(message, queue.Result{Status: result})
+		message.AddAttempt(w.id, result)
+	}
+	deadLetter := queue.NewDeadLetter(request, message, reason)
+	if err := w.handler.redisClient.AddDeadLetter(deadLetter); err != nil {
+		w.log.Printf("Unable to add dead letter: %s\n", err)
+	} else {
+		w.log.Printf("Request is dead-lettered as %s.\n", deadLetter.ID)
+	}
+	return result
+}
+
+// handleQuery computes the report of the tenant, caches it and returns it as JSON.
+func (w *worker) handleQuery(ctx context.Context, query *queries.Query, tenant string,
+	params queries.Params) (json.RawMessage, error) {
+	handler := w.handler
+	cacheKey := query.CacheKey(tenant, params)
+	w.log.Printf("Incoming request: %s.", cacheKey)
+
+	started := time.Now()
+	handler.acquirePostgres()
+	result, err := query.Fetch(ctx, handler.postgresClient, tenant, params)
+	handler.releasePostgres()
+	if err != nil {
+		w.log.Printf("Failed to get %s from postgres: %s\n", query.Name, err)
+		return nil, fmt.Errorf("failed to get %s from postgres: %s", query.Name, err)
+	}
+	fields, err := query.Encode(result, time.Now())
+	if err != nil {
+		w.log.Printf("Failed to encode %s result: %s\n", query.Name, err)
+		return nil, fmt.Errorf("failed to encode %s result: %s", query.Name, err)
+	}
+	if err := handler.redisClient.SetCache(cacheKey, fields, query.TTL); err != nil {
+		w.log.Printf("Failed to set %s cache: %s\n", cacheKey, err)
+		return nil, fmt.Errorf("failed to set %s cache: %s", cacheKey, err)
+	}
+	w.log.Printf("%s is got from db successfully.\n", query.Name)
+
+	refresh := models.ReportRefresh{
+		Report:      query.Name,
+		CacheKey:    cacheKey,
+		RefreshedAt: time.Now(),
+		Duration:    time.Since(started),
+	}
+	if err := handler.redisClient.SetReportRefresh(refresh); err != nil {
+		w.log.Printf("Failed to save %s refresh stats: %s\n", cacheKey, err)
+	}
+	return json.Marshal(result)
+}
+
+func (w *worker) publishResult(message *queue.Message, result queue.Result) {
+	w.publish(message.ReplyTo, result)
+}
+
+// setJobStatus updates the job state and streams the status event if the request is an async job.
+func (w *worker) setJobStatus(message *queue.Message, status string) {
+	if id, ok := queue.JobID(message.ReplyTo); ok {
+		now := time.Now()
+		event := queue.JobEvent{Event: status, Status: status, At: now}
+		w.handler.updateJob(w.log, id, queue.JobStatusFields(status, now), event)
+	}
+}
+
+// setJobThrottled saves the delayed request and its retry time to the job state.
+func (w *worker) setJobThrottled(message *queue.Message, request string, retryAt time.Time) {
+	if id, ok := queue.JobID(message.ReplyTo); ok {
+		now := time.Now()
+		event := queue.JobEvent{Event: queue.JobThrottled, Status: queue.JobThrottled, RetryAt: &retryAt, At: now}
+		w.handler.updateJob(w.log, id, queue.JobThrottledFields(request, retryAt, now), event)
+	}
+}
+
+// publish sends the result to the reply topic, the result of an async job is saved to its state.
+func (w *worker) publish(topic string, result queue.Result) {
+	if id, ok := queue.JobID(topic); ok {
+		now := time.Now()
+		w.handler.updateJob(w.log, id, queue.JobResultFields(result, now), queue.NewJobResultEvent(result, now))
+	}
+
+	encoded, err := result.Encode()
+	if err != nil {
+		w.log.Printf("Failed to encode result: %s\n", err)
+		return
+	}
+	if err := w.handler.redisClient.PublishResult(topic, encoded); err != nil {
+		w.log.Printf("Failed to publish result: %s\n", err)
+	} else {
+		w.log.Printf("Result '%s' is published successfully to topic %s.\n", result.Status, topic)
+	}
+}
+
+// cachedPayload converts the cached report to JSON published with the result.
+func cachedPayload(query *queries.Query, fields map[string]string) (json.RawMessage, error) {
+	report, err := query.Decode(fields)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(report)
+}
+
+func newWorker(handler *QueueHandler, number int, id string) *worker {
+	prefix := fmt.Sprintf("[worker %d] ", number)
+	return &worker{
+		handler: handler,
+		log:     log.New(handler.log.Writer(), prefix, handler.log.Flags()),
+		number:  number,
+		id:      id,
+		stats:   &workerStats{},
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"sync"
+	"testing"
+	"time"
+	"warehouse-system/config"
+	"warehouse-system/pkg/postgres"
+	"warehouse-system/pkg/queries"
+	"warehouse-system/pkg/queue"
+	"warehouse-system/pkg/ratelimit"
+	"warehouse-system/pkg/redis"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+const testQuery = "test:report"
+
+type testRow struct {
+	Key string `json:"key"`
+}
+
+// runningQueries tracks queries of each tenant running at the same time.
+type runningQueries struct {
+	mu        sync.Mutex
+	running   map[string]int
+	max       map[string]int
+	completed map[string]int
+}
+
+func (r *runningQueries) start(tenant string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.running[tenant]++
+	if r.running[tenant] > r.max[tenant] {
+		r.max[tenant] = r.running[tenant]
+	}
+}
+
+func (r *runningQueries) finish(tenant string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.running[tenant]--
+	r.completed[tenant]++
+}
+
+func (r *runningQueries) snapshot() (max, completed map[string]int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	max, completed = make(map[string]int), make(map[string]int)
+	for tenant, count := range r.max {
+		max[tenant] = count
+	}
+	for tenant, count := range r.completed {
+		completed[tenant] = count
+	}
+	return max, completed
+}
+
+func TestWorkerPoolLimitsTokens(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	logger := log.New(ioutil.Discard, "", 0)
+	appConfig := config.NewAppConfig()
+	appConfig.RedisHost = mr.Host()
+	appConfig.RedisPort = mr.Port()
+	appConfig.WorkerCount = 8
+	appConfig.PostgresMaxConcurrency = appConfig.WorkerCount
+	appConfig.CacheInvalidation = false
+	appConfig.RetryPollInterval = 10
+	appConfig.ShutdownTimeout = 5
+
+	// workers release leases after the pool is cancelled, so redis isn't bound to the pool context
+	redisClient := redis.NewRedisClient(context.Background(), logger, appConfig)
+	if redisClient == nil {
+		t.Fatal("unable to connect to redis")
+	}
+	defer redisClient.Close()
+
+	// every token queries the report of its own tenant, so queries are tracked by token
+	running := &runningQueries{running: map[string]int{}, max: map[string]int{}, completed: map[string]int{}}
+	registry := queries.NewRegistry()
+	registry.Register(&queries.Query{
+		Name:    testQuery,
+		Path:    "/test",
+		SoftTTL: time.Minute,
+		TTL:     time.Minute,
+		Row:     testRow{},
+		RowKey: func(row interface{}) string {
+			return row.(testRow).Key
+		},
+		Fetch: func(ctx context.Context, pg *postgres.Client, tenant string, params queries.Params) (interface{}, error) {
+			running.start(tenant)
+			defer running.finish(tenant)
+			time.Sleep(20 * time.Millisecond)
+			return []testRow{{Key: tenant}}, nil
+		},
+	})
+
+	handler := NewQueueHandler(logger, appConfig, redisClient, nil, registry)
+	if handler == nil {
+		t.Fatal("unable to create queue handler")
+	}
+	// throttled requests are retried right away unless the rate limit tells otherwise
+	handler.backoff = func(int) time.Duration { return 10 * time.Millisecond }
+
+	tests := []struct {
+		plan     ratelimit.Plan
+		requests int
+		// succeeded requests, the others wait for the rate limit in the delayed queue
+		succeeded int
+	}{
+		{ratelimit.Plan{Name: "serial", Concurrency: 1, MaxRetryCount: 1000}, 10, 10},
+		{ratelimit.Plan{Name: "concurrent", Concurrency: 3, MaxRetryCount: 1000}, 20, 20},
+		{ratelimit.Plan{Name: "rated", Concurrency: 8, QueryRateRequests: 1, RatePeriod: 3600, Burst: 5,
+			MaxRetryCount: 1000}, 12, 5},
+	}
+	for _, test := range tests {
+		if err := handler.plans.Save(test.plan); err != nil {
+			t.Fatal(err)
+		}
+		if err := handler.plans.Assign(test.plan.Name, test.plan.Name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// requests of the tokens are interleaved, so workers process them at the same time
+	for i := 0; i < 20; i++ {
+		for _, test := range tests {
+			if i >= test.requests {
+				continue
+			}
+			asOf := time.Unix(int64(i), 0)
+			message := queue.NewMessage(test.plan.Name, test.plan.Name, queue.NewMessageID(), testQuery,
+				queries.Params{AsOf: &asOf}, time.Now().Add(time.Minute), queue.NewTraceContext("", ""))
+			request, err := message.Encode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := redisClient.PutRequestToQueue(request); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		handler.Run(ctx)
+		close(done)
+	}()
+
+	delayed := func() map[string]int {
+		counts := make(map[string]int)
+		members, _ := mr.ZMembers("requests:delayed")
+		for _, member := range members {
+			message, err := queue.Decode(member)
+			if err != nil {
+				t.Fatal(err)
+			}
+			counts[message.Token]++
+		}
+		return counts
+	}
+	settled := func() bool {
+		_, completed := running.snapshot()
+		waiting := delayed()
+		for _, test := range tests {
+			if completed[test.plan.Name] != test.succeeded ||
+				waiting[test.plan.Name] != test.requests-test.succeeded {
+				return false
+			}
+		}
+		queued, _ := mr.List("requests")
+		return len(queued) == 0
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for !settled() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	max, completed := running.snapshot()
+	waiting := delayed()
+	for _, test := range tests {
+		token := test.plan.Name
+		if completed[token] != test.succeeded {
+			t.Errorf("%s: %d requests succeeded, want %d", token, completed[token], test.succeeded)
+		}
+		if waiting[token] != test.requests-test.succeeded {
+			t.Errorf("%s: %d requests are delayed, want %d", token, waiting[token], test.requests-test.succeeded)
+		}
+
+		// the pool runs as many queries of the token as its plan allows, but not more;
+		// queries of a rate limited token don't have to run at the same time
+		if max[token] > test.plan.Concurrency {
+			t.Errorf("%s: %d queries ran at the same time, the limit is %d", token, max[token], test.plan.Concurrency)
+		} else if !test.plan.QueryLimit().Enabled() && max[token] < test.plan.Concurrency {
+			t.Errorf("%s: at most %d queries ran at the same time, want %d", token, max[token], test.plan.Concurrency)
+		}
+
+		// leases of finished and throttled requests are released
+		if leases, err := mr.ZMembers("leases:" + token); err == nil && len(leases) != 0 {
+			t.Errorf("%s: leases %v are not released", token, leases)
+		}
+
+		// the rate limit is used up by the succeeded requests only
+		decision, err := handler.limiter.Peek(token, test.plan.QueryLimit())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.plan.QueryLimit().Enabled() && (decision.Allowed || decision.Remaining != 0) {
+			t.Errorf("%s: the rate limit has %d requests remaining, want 0", token, decision.Remaining)
+		}
+	}
+
+	var succeeded, failed, rejected int
+	for _, w := range handler.workers {
+		succeeded += w.stats.succeeded
+		failed += w.stats.failed
+		rejected += w.stats.rejected
+	}
+	if failed != 0 || rejected != 0 {
+		t.Errorf("%d requests failed and %d were rejected, want none", failed, rejected)
+	}
+	if want := 10 + 20 + 5; succeeded != want {
+		t.Errorf("workers succeeded %d requests, want %d", succeeded, want)
+	}
+}
@@ -4,8 +4,15 @@ import (
 	"context"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 	"warehouse-system/config"
 	"warehouse-system/pkg/api"
+	"warehouse-system/pkg/jwks"
+	"warehouse-system/pkg/postgres"
+	"warehouse-system/pkg/queries"
+	"warehouse-system/pkg/ratelimit"
 	"warehouse-system/pkg/redis"
 	"warehouse-system/pkg/services"
 )
@@ -22,7 +29,7 @@ func main() {
 
 	appConfig := config.NewAppConfig()
 	if err := appConfig.Load(path, file); err != nil {
-		logger.Printf("Unable to load app config from %s/%s\n", path, file)
+		logger.Printf("Unable to load app config from %s/%s: %s\n", path, file, err)
 		return
 	}
 
@@ -33,8 +40,63 @@ func main() {
 	}
 	defer redisClient.Close()
 
+	postgresClient := postgres.NewClient(logger, appConfig)
+	if postgresClient == nil {
+		logger.Println("Unable to create new postgres client")
+		return
+	}
+	defer postgresClient.Close()
+
+	registry := queries.NewReportsRegistry(appConfig)
 	productService := services.NewProductService(logger, appConfig, redisClient)
+	deadLetterService := services.NewDeadLetterService(logger, redisClient)
+	jobService := services.NewJobService(logger, appConfig, redisClient)
+
+	limiter, err := redisClient.NewLimiter(appConfig.RateLimitAlgorithm, ratelimit.Requests)
+	if err != nil {
+		logger.Printf("Unable to create rate limiter: %s\n", err)
+		return
+	}
+	// the web server reads the state of the query limiter to tell throttled clients when to retry
+	queryLimiter, err := redisClient.NewLimiter(appConfig.RateLimitAlgorithm, ratelimit.Queries)
+	if err != nil {
+		logger.Printf("Unable to create rate limiter: %s\n", err)
+		return
+	}
+	authLimiter, err := redisClient.NewLimiter(appConfig.RateLimitAlgorithm, ratelimit.AuthFailures)
+	if err != nil {
+		logger.Printf("Unable to create rate limiter: %s\n", err)
+		return
+	}
+	planService := services.NewPlanService(logger, appConfig, redisClient)
+	apiKeyService := services.NewAPIKeyService(logger, appConfig, redisClient, postgresClient)
+	clientService := services.NewClientService(logger, redisClient, postgresClient)
+	rateLimitService := services.NewRateLimitService(logger, appConfig, planService, limiter, queryLimiter,
+		authLimiter, redisClient.NewQuotas())
+
+	shutdownCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// bearer tokens are accepted if the JWKS file of their issuer is configured
+	var jwtService *services.JWTService
+	if appConfig.JWKSFile != "" {
+		keys, err := jwks.NewKeySet(logger, appConfig.JWKSFile)
+		if err != nil {
+			logger.Printf("Unable to load JWKS file: %s\n", err)
+			return
+		}
+		go keys.Watch(shutdownCtx)
+		jwtService = services.NewJWTService(logger, appConfig, keys, redisClient, postgresClient)
+	}
+
+	webServer := api.NewWebServer(appConfig.WebServerHost, appConfig.WebServerPort, logger, productService, registry,
+		appConfig.AdminToken, deadLetterService, jobService, rateLimitService, planService,
+		apiKeyService, jwtService, clientService)
 
-	webServer := api.NewWebServer(appConfig.WebServerHost, appConfig.WebServerPort, logger, productService)
-	webServer.Run()
+	shutdownTimeout := time.Duration(appConfig.ShutdownTimeout) * time.Second
+	if err := webServer.Run(shutdownCtx, shutdownTimeout); err != nil {
+		logger.Printf("Web server stopped with error: %s\n", err)
+		return
+	}
+	logger.Println("Web server stopped.")
 }
@@ -1,27 +1,65 @@
 package config
 
 import (
+	"errors"
 	"github.com/spf13/viper"
 	"sync"
+	"time"
 )
 
 type AppConfig struct {
-	PostgresHost           string `mapstructure:"POSTGRES_HOST"`
-	PostgresPort           string `mapstructure:"POSTGRES_PORT"`
-	PostgresDB             string `mapstructure:"POSTGRES_DB"`
-	PostgresUser           string `mapstructure:"POSTGRES_USER"`
-	PostgresPassword       string `mapstructure:"POSTGRES_PASSWORD"`
-	PostgresSslMode        string `mapstructure:"POSTGRES_SSLMODE"`
-	PostgresMigrationsPath string `mapstructure:"POSTGRES_MIGRATIONS_PATH"`
-	RedisHost              string `mapstructure:"REDIS_HOST"`
-	RedisPort              string `mapstructure:"REDIS_PORT"`
-	RedisPassword          string `mapstructure:"REDIS_PASSWORD"`
-	WebServerHost          string `mapstructure:"WEB_SERVER_HOST"`
-	WebServerPort          string `mapstructure:"WEB_SERVER_PORT"`
-	CacheExpireDuration    int    `mapstructure:"CACHE_EXPIRE_DURATION"`
-	SubscribeTimeout       int    `mapstructure:"SUBSCRIBE_TIMEOUT"`
-	MaxRequestsCount       int    `mapstructure:"MAX_REQUESTS_COUNT"`
-	MaxRetryCount          int    `mapstructure:"MAX_RETRY_COUNT"`
+	PostgresHost            string `mapstructure:"POSTGRES_HOST"`
+	PostgresPort            string `mapstructure:"POSTGRES_PORT"`
+	PostgresDB              string `mapstructure:"POSTGRES_DB"`
+	PostgresUser            string `mapstructure:"POSTGRES_USER"`
+	PostgresPassword        string `mapstructure:"POSTGRES_PASSWORD"`
+	PostgresSslMode         string `mapstructure:"POSTGRES_SSLMODE"`
+	PostgresMigrationsPath  string `mapstructure:"POSTGRES_MIGRATIONS_PATH"`
+	RedisHost               string `mapstructure:"REDIS_HOST"`
+	RedisPort               string `mapstructure:"REDIS_PORT"`
+	RedisPassword           string `mapstructure:"REDIS_PASSWORD"`
+	WebServerHost           string `mapstructure:"WEB_SERVER_HOST"`
+	WebServerPort           string `mapstructure:"WEB_SERVER_PORT"`
+	CacheExpireDuration     int    `mapstructure:"CACHE_EXPIRE_DURATION"`
+	CacheHardExpireDuration int    `mapstructure:"CACHE_HARD_EXPIRE_DURATION"`
+	SubscribeTimeout        int    `mapstructure:"SUBSCRIBE_TIMEOUT"`
+	MaxRetryCount           int    `mapstructure:"MAX_RETRY_COUNT"`
+	ReliableQueue           bool   `mapstructure:"RELIABLE_QUEUE"`
+	WorkerHeartbeatInterval int    `mapstructure:"WORKER_HEARTBEAT_INTERVAL"`
+	WorkerHeartbeatTimeout  int    `mapstructure:"WORKER_HEARTBEAT_TIMEOUT"`
+	RetryStrategy           string `mapstructure:"RETRY_STRATEGY"`
+	RetryPollInterval       int    `mapstructure:"RETRY_POLL_INTERVAL"`
+	WorkerCount             int    `mapstructure:"WORKER_COUNT"`
+	PostgresMaxConcurrency  int    `mapstructure:"POSTGRES_MAX_CONCURRENCY"`
+	WorkerStatsInterval     int    `mapstructure:"WORKER_STATS_INTERVAL"`
+	ShutdownTimeout         int    `mapstructure:"SHUTDOWN_TIMEOUT"`
+	AdminToken              string `mapstructure:"ADMIN_TOKEN"`
+	InflightTimeout         int    `mapstructure:"INFLIGHT_TIMEOUT"`
+	RefreshSchedule         string `mapstructure:"REFRESH_SCHEDULE"`
+	SystemToken             string `mapstructure:"SYSTEM_TOKEN"`
+	CacheInvalidation       bool   `mapstructure:"CACHE_INVALIDATION"`
+	InvalidationDebounce    int    `mapstructure:"INVALIDATION_DEBOUNCE"`
+	JobTTL                  int    `mapstructure:"JOB_TTL"`
+	RateLimitAlgorithm      string `mapstructure:"RATE_LIMIT_ALGORITHM"`
+	RateLimitRequests       int    `mapstructure:"RATE_LIMIT_REQUESTS"`
+	RateLimitPeriod         int    `mapstructure:"RATE_LIMIT_PERIOD"`
+	RateLimitBurst          int    `mapstructure:"RATE_LIMIT_BURST"`
+	QueryRateLimitRequests  int    `mapstructure:"QUERY_RATE_LIMIT_REQUESTS"`
+	QueryConcurrency        int    `mapstructure:"QUERY_CONCURRENCY"`
+	DailyQuota              int    `mapstructure:"DAILY_QUOTA"`
+	PlanCacheTTL            int    `mapstructure:"PLAN_CACHE_TTL"`
+	AuthCacheTTL            int    `mapstructure:"AUTH_CACHE_TTL"`
+	AuthFailureCacheTTL     int    `mapstructure:"AUTH_FAILURE_CACHE_TTL"`
+	AuthFailureLimit        int    `mapstructure:"AUTH_FAILURE_LIMIT"`
+	AuthFailurePeriod       int    `mapstructure:"AUTH_FAILURE_PERIOD"`
+	JWKSFile                string `mapstructure:"JWKS_FILE"`
+	JWTIssuer               string `mapstructure:"JWT_ISSUER"`
+	JWTAudience             string `mapstructure:"JWT_AUDIENCE"`
+	JWTScopeClaim           string `mapstructure:"JWT_SCOPE_CLAIM"`
+	JWTTenantClaim          string `mapstructure:"JWT_TENANT_CLAIM"`
+	// LegacyMessagesUntil is an RFC3339 time until which query workers accept queue messages
+	// in the colon format of web servers released before the JSON envelope
+	LegacyMessagesUntil string `mapstructure:"LEGACY_MESSAGES_UNTIL"`
 }
 
 func (config *AppConfig) SetDefault() {
@@ -36,9 +74,37 @@ func (config *AppConfig) SetDefault() {
 	config.WebServerHost = "localhost"
 	config.WebServerPort = "80"
 	config.CacheExpireDuration = 30
+	config.CacheHardExpireDuration = 300
 	config.SubscribeTimeout = 5
-	config.MaxRequestsCount = 10
 	config.MaxRetryCount = 10
+	config.ReliableQueue = false
+	config.WorkerHeartbeatInterval = 5
+	config.WorkerHeartbeatTimeout = 30
+	config.RetryStrategy = "fibonacci"
+	config.RetryPollInterval = 500
+	config.WorkerCount = 1
+	config.PostgresMaxConcurrency = 4
+	config.WorkerStatsInterval = 60
+	config.ShutdownTimeout = 15
+	config.InflightTimeout = 60
+	config.SystemToken = "system"
+	config.CacheInvalidation = true
+	config.InvalidationDebounce = 1000
+	config.JobTTL = 3600
+	config.RateLimitAlgorithm = "token_bucket"
+	config.RateLimitRequests = 60
+	config.RateLimitPeriod = 60
+	config.RateLimitBurst = 20
+	config.QueryRateLimitRequests = 30
+	config.QueryConcurrency = 10
+	config.DailyQuota = 0
+	config.PlanCacheTTL = 10
+	config.AuthCacheTTL = 60
+	config.AuthFailureCacheTTL = 5
+	config.AuthFailureLimit = 10
+	config.AuthFailurePeriod = 60
+	config.JWTScopeClaim = "scope"
+	config.JWTTenantClaim = "tenant"
 }
 
 func (config *AppConfig) Load(path, name string) (err error) {
@@ -64,12 +130,77 @@ func (config *AppConfig) Load(path, name string) (err error) {
 		viper.BindEnv("WEB_SERVER_HOST")
 		viper.BindEnv("WEB_SERVER_PORT")
 		viper.BindEnv("CACHE_EXPIRE_DURATION")
+		viper.BindEnv("CACHE_HARD_EXPIRE_DURATION")
 		viper.BindEnv("SUBSCRIBE_TIMEOUT")
-		viper.BindEnv("MAX_REQUESTS_COUNT")
 		viper.BindEnv("MAX_RETRY_COUNT")
-		return viper.Unmarshal(config)
+		viper.BindEnv("RELIABLE_QUEUE")
+		viper.BindEnv("WORKER_HEARTBEAT_INTERVAL")
+		viper.BindEnv("WORKER_HEARTBEAT_TIMEOUT")
+		viper.BindEnv("RETRY_STRATEGY")
+		viper.BindEnv("RETRY_POLL_INTERVAL")
+		viper.BindEnv("WORKER_COUNT")
+		viper.BindEnv("POSTGRES_MAX_CONCURRENCY")
+		viper.BindEnv("WORKER_STATS_INTERVAL")
+		viper.BindEnv("SHUTDOWN_TIMEOUT")
+		viper.BindEnv("ADMIN_TOKEN")
+		viper.BindEnv("INFLIGHT_TIMEOUT")
+		viper.BindEnv("REFRESH_SCHEDULE")
+		viper.BindEnv("SYSTEM_TOKEN")
+		viper.BindEnv("CACHE_INVALIDATION")
+		viper.BindEnv("INVALIDATION_DEBOUNCE")
+		viper.BindEnv("JOB_TTL")
+		viper.BindEnv("RATE_LIMIT_ALGORITHM")
+		viper.BindEnv("RATE_LIMIT_REQUESTS")
+		viper.BindEnv("RATE_LIMIT_PERIOD")
+		viper.BindEnv("RATE_LIMIT_BURST")
+		viper.BindEnv("QUERY_RATE_LIMIT_REQUESTS")
+		viper.BindEnv("QUERY_CONCURRENCY")
+		viper.BindEnv("DAILY_QUOTA")
+		viper.BindEnv("PLAN_CACHE_TTL")
+		viper.BindEnv("AUTH_CACHE_TTL")
+		viper.BindEnv("AUTH_FAILURE_CACHE_TTL")
+		viper.BindEnv("AUTH_FAILURE_LIMIT")
+		viper.BindEnv("AUTH_FAILURE_PERIOD")
+		viper.BindEnv("JWKS_FILE")
+		viper.BindEnv("JWT_ISSUER")
+		viper.BindEnv("JWT_AUDIENCE")
+		viper.BindEnv("JWT_SCOPE_CLAIM")
+		viper.BindEnv("JWT_TENANT_CLAIM")
+		viper.BindEnv("LEGACY_MESSAGES_UNTIL")
 	}
-	return viper.Unmarshal(config)
+	if err := viper.Unmarshal(config); err != nil {
+		return err
+	}
+	return config.Validate()
+}
+
+// Validate checks settings that are invalid alone or together.
+func (config *AppConfig) Validate() error {
+	// queries of tokens on the default plan would never run
+	if config.QueryConcurrency < 1 {
+		return errors.New("QUERY_CONCURRENCY must be positive")
+	}
+	// requests drained on shutdown would be re-queued by reapers of other workers and run twice
+	if config.ReliableQueue && config.WorkerHeartbeatTimeout <= config.ShutdownTimeout {
+		return errors.New("WORKER_HEARTBEAT_TIMEOUT must be greater than SHUTDOWN_TIMEOUT")
+	}
+	// tokens signed by any key of the JWKS file must not be accepted for other services
+	if config.JWKSFile != "" && (config.JWTIssuer == "" || config.JWTAudience == "") {
+		return errors.New("JWT_ISSUER and JWT_AUDIENCE must be set with JWKS_FILE")
+	}
+	if config.LegacyMessagesUntil != "" {
+		if _, err := time.Parse(time.RFC3339, config.LegacyMessagesUntil); err != nil {
+			return errors.New("LEGACY_MESSAGES_UNTIL must be an RFC3339 time")
+		}
+	}
+	return nil
+}
+
+// LegacyMessagesAccepted reports whether legacy queue messages are still decoded. The legacy
+// decoder and the setting are removed in the release after no deployment sets it to the future.
+func (config *AppConfig) LegacyMessagesAccepted(now time.Time) bool {
+	until, err := time.Parse(time.RFC3339, config.LegacyMessagesUntil)
+	return err == nil && now.Before(until)
 }
 
 func NewAppConfig() *AppConfig {
@@ -1,5 +1,10 @@
 package errors
 
+import (
+	"fmt"
+	"time"
+)
+
 type BadRequestError struct {
 	Message string
 }
@@ -27,3 +32,43 @@ type MaxRetryCountExceededError struct{}
 func (err MaxRetryCountExceededError) Error() string {
 	return "max retry count exceeded"
 }
+
+type UnknownQueryError struct {
+	Name string
+}
+
+func (err UnknownQueryError) Error() string {
+	return "unknown query " + err.Name
+}
+
+type NotFoundError struct {
+	Message string
+}
+
+func (err NotFoundError) Error() string {
+	return err.Message
+}
+
+type RateLimitExceededError struct {
+	RetryAfter time.Duration
+}
+
+func (err RateLimitExceededError) Error() string {
+	return fmt.Sprintf("rate limit exceeded, retry after %s", err.RetryAfter.Round(time.Second))
+}
+
+type UnauthorizedError struct {
+	Message string
+}
+
+func (err UnauthorizedError) Error() string {
+	return err.Message
+}
+
+type ConflictError struct {
+	Message string
+}
+
+func (err ConflictError) Error() string {
+	return err.Message
+}
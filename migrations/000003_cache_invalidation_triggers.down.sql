@@ -0,0 +1,4 @@
+DROP TRIGGER manufacturers_cache_invalidation ON manufacturers;
+DROP TRIGGER products_cache_invalidation ON products;
+DROP TRIGGER orders_cache_invalidation ON orders;
+DROP FUNCTION notify_cache_invalidation();
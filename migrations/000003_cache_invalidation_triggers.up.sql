@@ -0,0 +1,18 @@
+CREATE OR REPLACE FUNCTION notify_cache_invalidation() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('cache_invalidation', TG_TABLE_NAME);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER orders_cache_invalidation
+AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON orders
+FOR EACH STATEMENT EXECUTE PROCEDURE notify_cache_invalidation();
+
+CREATE TRIGGER products_cache_invalidation
+AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON products
+FOR EACH STATEMENT EXECUTE PROCEDURE notify_cache_invalidation();
+
+CREATE TRIGGER manufacturers_cache_invalidation
+AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON manufacturers
+FOR EACH STATEMENT EXECUTE PROCEDURE notify_cache_invalidation();
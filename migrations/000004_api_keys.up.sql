@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS api_keys
+(
+    id          serial          not null unique,
+    client_id   int             not null references clients(id) on delete cascade,
+    name        varchar(128)    not null,
+    prefix      varchar(16)     not null,
+    key_hash    char(64)        not null unique,
+    created_at  timestamp       not null default now(),
+    expires_at  timestamp,
+    revoked_at  timestamp
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_client_id_idx ON api_keys(client_id);
@@ -0,0 +1,2 @@
+ALTER TABLE api_keys
+ADD COLUMN IF NOT EXISTS scopes text[] not null default '{reports:read}';
@@ -0,0 +1,13 @@
+ALTER TABLE manufacturers DROP CONSTRAINT IF EXISTS manufacturers_tenant_id_code_key;
+ALTER TABLE manufacturers ADD CONSTRAINT manufacturers_code_key UNIQUE (code);
+ALTER TABLE manufacturers DROP CONSTRAINT IF EXISTS manufacturers_tenant_id_name_key;
+ALTER TABLE manufacturers ADD CONSTRAINT manufacturers_name_key UNIQUE (name);
+ALTER TABLE clients DROP CONSTRAINT IF EXISTS clients_tenant_id_phone_key;
+ALTER TABLE clients ADD CONSTRAINT clients_phone_key UNIQUE (phone);
+
+ALTER TABLE orders DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE products DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE manufacturers DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE clients DROP COLUMN IF EXISTS tenant_id;
+
+DROP TABLE IF EXISTS tenants;
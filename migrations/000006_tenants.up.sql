@@ -0,0 +1,35 @@
+CREATE TABLE IF NOT EXISTS tenants
+(
+    id          varchar(64)     not null unique,
+    name        varchar(128)    not null
+);
+
+INSERT INTO tenants (id, name) VALUES ('default', 'Default') ON CONFLICT DO NOTHING;
+
+-- existing rows belong to the default tenant, new rows must name their tenant
+ALTER TABLE clients
+ADD COLUMN IF NOT EXISTS tenant_id varchar(64) not null default 'default' references tenants(id) on delete cascade;
+ALTER TABLE clients ALTER COLUMN tenant_id DROP DEFAULT;
+
+ALTER TABLE manufacturers
+ADD COLUMN IF NOT EXISTS tenant_id varchar(64) not null default 'default' references tenants(id) on delete cascade;
+ALTER TABLE manufacturers ALTER COLUMN tenant_id DROP DEFAULT;
+
+ALTER TABLE products
+ADD COLUMN IF NOT EXISTS tenant_id varchar(64) not null default 'default' references tenants(id) on delete cascade;
+ALTER TABLE products ALTER COLUMN tenant_id DROP DEFAULT;
+
+ALTER TABLE orders
+ADD COLUMN IF NOT EXISTS tenant_id varchar(64) not null default 'default' references tenants(id) on delete cascade;
+ALTER TABLE orders ALTER COLUMN tenant_id DROP DEFAULT;
+
+-- tenants may have clients with the same phone and manufacturers with the same name or code
+ALTER TABLE clients DROP CONSTRAINT IF EXISTS clients_phone_key;
+ALTER TABLE clients ADD CONSTRAINT clients_tenant_id_phone_key UNIQUE (tenant_id, phone);
+ALTER TABLE manufacturers DROP CONSTRAINT IF EXISTS manufacturers_name_key;
+ALTER TABLE manufacturers ADD CONSTRAINT manufacturers_tenant_id_name_key UNIQUE (tenant_id, name);
+ALTER TABLE manufacturers DROP CONSTRAINT IF EXISTS manufacturers_code_key;
+ALTER TABLE manufacturers ADD CONSTRAINT manufacturers_tenant_id_code_key UNIQUE (tenant_id, code);
+
+CREATE INDEX IF NOT EXISTS products_tenant_id_idx ON products(tenant_id);
+CREATE INDEX IF NOT EXISTS orders_tenant_id_idx ON orders(tenant_id);
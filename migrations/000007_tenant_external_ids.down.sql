@@ -0,0 +1,8 @@
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_tenant_id_external_id_key;
+ALTER TABLE orders ADD CONSTRAINT orders_external_id_key UNIQUE (external_id);
+ALTER TABLE products DROP CONSTRAINT IF EXISTS products_tenant_id_external_id_key;
+ALTER TABLE products ADD CONSTRAINT products_external_id_key UNIQUE (external_id);
+ALTER TABLE manufacturers DROP CONSTRAINT IF EXISTS manufacturers_tenant_id_external_id_key;
+ALTER TABLE manufacturers ADD CONSTRAINT manufacturers_external_id_key UNIQUE (external_id);
+ALTER TABLE clients DROP CONSTRAINT IF EXISTS clients_tenant_id_external_id_key;
+ALTER TABLE clients ADD CONSTRAINT clients_external_id_key UNIQUE (external_id);
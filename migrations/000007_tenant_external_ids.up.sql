@@ -0,0 +1,9 @@
+-- external ids are unique within a tenant, so tenants can't probe ids of each other
+ALTER TABLE clients DROP CONSTRAINT IF EXISTS clients_external_id_key;
+ALTER TABLE clients ADD CONSTRAINT clients_tenant_id_external_id_key UNIQUE (tenant_id, external_id);
+ALTER TABLE manufacturers DROP CONSTRAINT IF EXISTS manufacturers_external_id_key;
+ALTER TABLE manufacturers ADD CONSTRAINT manufacturers_tenant_id_external_id_key UNIQUE (tenant_id, external_id);
+ALTER TABLE products DROP CONSTRAINT IF EXISTS products_external_id_key;
+ALTER TABLE products ADD CONSTRAINT products_tenant_id_external_id_key UNIQUE (tenant_id, external_id);
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_external_id_key;
+ALTER TABLE orders ADD CONSTRAINT orders_tenant_id_external_id_key UNIQUE (tenant_id, external_id);
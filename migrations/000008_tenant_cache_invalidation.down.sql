@@ -0,0 +1,21 @@
+DROP TRIGGER IF EXISTS orders_cache_truncation ON orders;
+DROP TRIGGER IF EXISTS products_cache_truncation ON products;
+DROP TRIGGER IF EXISTS manufacturers_cache_truncation ON manufacturers;
+
+DROP TRIGGER IF EXISTS orders_cache_invalidation ON orders;
+DROP TRIGGER IF EXISTS products_cache_invalidation ON products;
+DROP TRIGGER IF EXISTS manufacturers_cache_invalidation ON manufacturers;
+
+CREATE TRIGGER orders_cache_invalidation
+AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON orders
+FOR EACH STATEMENT EXECUTE PROCEDURE notify_cache_invalidation();
+
+CREATE TRIGGER products_cache_invalidation
+AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON products
+FOR EACH STATEMENT EXECUTE PROCEDURE notify_cache_invalidation();
+
+CREATE TRIGGER manufacturers_cache_invalidation
+AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON manufacturers
+FOR EACH STATEMENT EXECUTE PROCEDURE notify_cache_invalidation();
+
+DROP FUNCTION IF EXISTS notify_tenant_cache_invalidation();
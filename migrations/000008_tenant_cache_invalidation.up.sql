@@ -0,0 +1,42 @@
+-- the payload is the table name and the tenant of the changed row, so only reports of the tenant are invalidated
+CREATE OR REPLACE FUNCTION notify_tenant_cache_invalidation() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' OR TG_OP = 'DELETE' THEN
+        PERFORM pg_notify('cache_invalidation', TG_TABLE_NAME || ':' || OLD.tenant_id);
+    END IF;
+    IF TG_OP = 'UPDATE' OR TG_OP = 'INSERT' THEN
+        PERFORM pg_notify('cache_invalidation', TG_TABLE_NAME || ':' || NEW.tenant_id);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS orders_cache_invalidation ON orders;
+DROP TRIGGER IF EXISTS products_cache_invalidation ON products;
+DROP TRIGGER IF EXISTS manufacturers_cache_invalidation ON manufacturers;
+
+-- identical notifications of a transaction are delivered once, so bulk changes notify once per tenant
+CREATE TRIGGER orders_cache_invalidation
+AFTER INSERT OR UPDATE OR DELETE ON orders
+FOR EACH ROW EXECUTE PROCEDURE notify_tenant_cache_invalidation();
+
+CREATE TRIGGER products_cache_invalidation
+AFTER INSERT OR UPDATE OR DELETE ON products
+FOR EACH ROW EXECUTE PROCEDURE notify_tenant_cache_invalidation();
+
+CREATE TRIGGER manufacturers_cache_invalidation
+AFTER INSERT OR UPDATE OR DELETE ON manufacturers
+FOR EACH ROW EXECUTE PROCEDURE notify_tenant_cache_invalidation();
+
+-- truncation has no rows, it invalidates reports of all tenants
+CREATE TRIGGER orders_cache_truncation
+AFTER TRUNCATE ON orders
+FOR EACH STATEMENT EXECUTE PROCEDURE notify_cache_invalidation();
+
+CREATE TRIGGER products_cache_truncation
+AFTER TRUNCATE ON products
+FOR EACH STATEMENT EXECUTE PROCEDURE notify_cache_invalidation();
+
+CREATE TRIGGER manufacturers_cache_truncation
+AFTER TRUNCATE ON manufacturers
+FOR EACH STATEMENT EXECUTE PROCEDURE notify_cache_invalidation();
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	e "warehouse-system/errors"
+)
+
+const deadLettersPath = "/admin/dead-letters"
+
+// DeadLettersHandler serves:
+//
+//	GET    /admin/dead-letters?offset=0&limit=50 - list dead letters, most recent first
+//	DELETE /admin/dead-letters                   - purge all dead letters
+//	GET    /admin/dead-letters/{id}              - inspect a dead letter
+//	DELETE /admin/dead-letters/{id}              - delete a dead letter
+//	POST   /admin/dead-letters/{id}/replay       - put a dead letter back to the queue
+func (server *WebServer) DeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, deadLettersPath), "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case path == "" && r.Method == http.MethodGet:
+		server.listDeadLetters(w, r)
+	case path == "" && r.Method == http.MethodDelete:
+		if err := server.deadLetterService.Purge(); err != nil {
+			server.writeServiceError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		deadLetter, err := server.deadLetterService.Get(parts[0])
+		if err != nil {
+			server.writeServiceError(w, err)
+			return
+		}
+		server.writeJSON(w, deadLetter, http.StatusOK)
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		if err := server.deadLetterService.Delete(parts[0]); err != nil {
+			server.writeServiceError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 2 && parts[1] == "replay" && r.Method == http.MethodPost:
+		if err := server.deadLetterService.Replay(parts[0]); err != nil {
+			server.writeServiceError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		server.writeError(w, errors.New("not found"), http.StatusNotFound)
+	}
+}
+
+// ReportRefreshesHandler returns the last refresh time and duration of every cached report.
+func (server *WebServer) ReportRefreshesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		server.writeError(w, errors.New("method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+	refreshes, err := server.productService.GetReportRefreshes()
+	if err != nil {
+		server.writeServiceError(w, err)
+		return
+	}
+	server.writeJSON(w, refreshes, http.StatusOK)
+}
+
+func (server *WebServer) listDeadLetters(w http.ResponseWriter, r *http.Request) {
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil || offset < 0 {
+		server.writeError(w, e.BadRequestError{Message: "offset must be a non-negative integer"}, http.StatusBadRequest)
+		return
+	}
+	limit, err := queryInt(r, "limit", 50)
+	if err != nil || limit < 1 || limit > 1000 {
+		server.writeError(w, e.BadRequestError{Message: "limit must be between 1 and 1000"}, http.StatusBadRequest)
+		return
+	}
+
+	deadLetters, err := server.deadLetterService.List(offset, limit)
+	if err != nil {
+		server.writeServiceError(w, err)
+		return
+	}
+	server.writeJSON(w, deadLetters, http.StatusOK)
+}
+
+func queryInt(r *http.Request, name string, defaultValue int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// writeServiceError maps service errors to HTTP status codes.
+func (server *WebServer) writeServiceError(w http.ResponseWriter, err error) {
+	var badRequestErr e.BadRequestError
+	var notFoundErr e.NotFoundError
+	var conflictErr e.ConflictError
+	switch {
+	case errors.As(err, &badRequestErr):
+		server.writeError(w, err, http.StatusBadRequest)
+	case errors.As(err, &notFoundErr):
+		server.writeError(w, err, http.StatusNotFound)
+	case errors.As(err, &conflictErr):
+		server.writeError(w, err, http.StatusConflict)
+	default:
+		server.log.Printf("Request failed: %s\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (server *WebServer) writeJSON(w http.ResponseWriter, value interface{}, statusCode int) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		server.log.Printf("Unable to marshal response: %s\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if _, err := w.Write(data); err != nil {
+		server.log.Printf("Unable to send response: %s\n", err)
+	}
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	e "warehouse-system/errors"
+	"warehouse-system/pkg/models"
+)
+
+const apiKeysPath = "/admin/api-keys"
+
+// apiKeyRequest is the body of POST /admin/api-keys.
+type apiKeyRequest struct {
+	Tenant    string     `json:"tenant"`
+	ClientID  string     `json:"client_id"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// issuedAPIKey is the response to POST /admin/api-keys, it is the only time the token is shown.
+type issuedAPIKey struct {
+	models.APIKey
+	Token string
+}
+
+// APIKeysHandler serves:
+//
+//	GET    /admin/api-keys?tenant={tenant}&client_id={id} - list api keys, of all tenants or clients if omitted
+//	POST   /admin/api-keys                                - issue a key, {"tenant": "...", "client_id": "...", "name": "...", "scopes": [...], "expires_at": "..."}
+//	DELETE /admin/api-keys/{id}                           - revoke a key
+func (server *WebServer) APIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, apiKeysPath), "/")
+
+	switch {
+	case path == "" && r.Method == http.MethodGet:
+		keys, err := server.apiKeyService.List(r.Context(), r.URL.Query().Get("tenant"),
+			r.URL.Query().Get("client_id"))
+		if err != nil {
+			server.writeServiceError(w, err)
+			return
+		}
+		server.writeJSON(w, keys, http.StatusOK)
+	case path == "" && r.Method == http.MethodPost:
+		var body apiKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			server.writeError(w, e.BadRequestError{Message: "invalid api key request: " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+		key, token, err := server.apiKeyService.Create(r.Context(), body.Tenant, body.ClientID, body.Name,
+			body.Scopes, body.ExpiresAt)
+		if err != nil {
+			server.writeServiceError(w, err)
+			return
+		}
+		server.writeJSON(w, issuedAPIKey{APIKey: *key, Token: token}, http.StatusCreated)
+	case path != "" && r.Method == http.MethodDelete:
+		id, err := strconv.Atoi(path)
+		if err != nil {
+			server.writeError(w, e.BadRequestError{Message: "api key id must be an integer"}, http.StatusBadRequest)
+			return
+		}
+		if err := server.apiKeyService.Revoke(r.Context(), id); err != nil {
+			server.writeServiceError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		server.writeError(w, errors.New("not found"), http.StatusNotFound)
+	}
+}
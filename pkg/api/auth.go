@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	e "warehouse-system/errors"
+	"warehouse-system/pkg/models"
+)
+
+type principalKey struct{}
+
+// adminPrincipal is the principal of the admin token.
+var adminPrincipal = &models.Principal{
+	ID:     "admin",
+	Scopes: []string{models.ScopeAdmin},
+}
+
+// authenticated validates the API key of the Token header or the JWT of the Authorization
+// bearer header and passes the principal to the handler. Requests with missing or invalid
+// credentials get 401. The admin token is accepted as a key with the admin scope.
+// A client address with too many failed authentications gets 429 before credentials are looked up.
+func (server *WebServer) authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("Token")
+		bearer, hasBearer := bearerToken(r)
+		if token == "" && !hasBearer {
+			server.writeError(w, e.UnauthorizedError{Message: "token must be provided"}, http.StatusUnauthorized)
+			return
+		}
+
+		address := clientAddress(r)
+		decision, err := server.rateLimitService.CheckAuthFailures(address)
+		var rateLimitErr e.RateLimitExceededError
+		if errors.As(err, &rateLimitErr) {
+			server.writeTooManyRequests(w, err, decision)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if token != "" && server.adminToken != "" &&
+			subtle.ConstantTimeCompare([]byte(token), []byte(server.adminToken)) == 1 {
+			next(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, adminPrincipal)))
+			return
+		}
+
+		var principal *models.Principal
+		switch {
+		case token != "":
+			principal, err = server.apiKeyService.Authenticate(r.Context(), token)
+		case server.jwtService == nil:
+			err = e.UnauthorizedError{Message: "bearer tokens are not accepted"}
+		default:
+			principal, err = server.jwtService.Authenticate(r.Context(), bearer)
+		}
+		var unauthorizedErr e.UnauthorizedError
+		if errors.As(err, &unauthorizedErr) {
+			server.rateLimitService.CountAuthFailure(address)
+			server.writeError(w, err, http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			server.log.Printf("Unable to authenticate request: %s\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+	}
+}
+
+// clientAddress returns the IP address of the client connection. Forwarding headers are
+// ignored, as any client can set them.
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// bearerToken returns the token of the Authorization header with the Bearer scheme.
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) < len("Bearer ") || !strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(header[len("Bearer "):]), true
+}
+
+// requireScope allows principals with the scope the route requires for the request method only,
+// others get 403. Admin routes see data of all tenants, so only the tenant-less principal
+// of the admin token has the admin scope.
+func (server *WebServer) requireScope(route route, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal := principalFrom(r)
+		scope := route.requiredScope(r.Method)
+		if !principal.HasScope(scope) || (scope == models.ScopeAdmin && principal != adminPrincipal) {
+			server.writeError(w, fmt.Errorf("scope %s is required", scope), http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// principalFrom returns the principal of an authenticated request.
+func principalFrom(r *http.Request) *models.Principal {
+	principal, _ := r.Context().Value(principalKey{}).(*models.Principal)
+	return principal
+}
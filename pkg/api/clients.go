@@ -0,0 +1,107 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	e "warehouse-system/errors"
+	"warehouse-system/pkg/models"
+)
+
+const clientsPath = "/v1/clients"
+
+// clientRequest is the body of POST and PUT /v1/clients, the external id is read on creation only.
+type clientRequest struct {
+	ExternalID string `json:"external_id"`
+	Username   string `json:"username"`
+	Phone      string `json:"phone"`
+	Email      string `json:"email"`
+}
+
+// ClientsHandler serves clients of the tenant of the principal:
+//
+//	GET    /v1/clients?cursor={cursor}&limit=50 - list clients, the next page starts at the returned cursor
+//	POST   /v1/clients                          - create a client, {"external_id": "...", "username": "...", "phone": "+1...", "email": "..."}
+//	GET    /v1/clients/{external_id}            - get a client
+//	PUT    /v1/clients/{external_id}            - replace the username, phone and email of a client
+//	DELETE /v1/clients/{external_id}            - delete a client with its orders and api keys
+func (server *WebServer) ClientsHandler(w http.ResponseWriter, r *http.Request) {
+	tenant := principalFrom(r).Tenant
+	if tenant == "" {
+		server.writeError(w, e.BadRequestError{Message: "token has no tenant"}, http.StatusBadRequest)
+		return
+	}
+	externalID := strings.Trim(strings.TrimPrefix(r.URL.Path, clientsPath), "/")
+
+	switch {
+	case externalID == "" && r.Method == http.MethodGet:
+		limit, err := queryInt(r, "limit", 50)
+		if err != nil || limit < 1 || limit > 1000 {
+			server.writeError(w, e.BadRequestError{Message: "limit must be between 1 and 1000"}, http.StatusBadRequest)
+			return
+		}
+		page, err := server.clientService.List(r.Context(), tenant, r.URL.Query().Get("cursor"), limit)
+		if err != nil {
+			server.writeServiceError(w, err)
+			return
+		}
+		server.writeJSON(w, page, http.StatusOK)
+	case externalID == "" && r.Method == http.MethodPost:
+		body, ok := server.decodeClient(w, r)
+		if !ok {
+			return
+		}
+		client, err := server.clientService.Create(r.Context(), tenant, body)
+		if err != nil {
+			server.writeServiceError(w, err)
+			return
+		}
+		w.Header().Set("Location", clientsPath+"/"+client.ExternalID)
+		server.writeJSON(w, client, http.StatusCreated)
+	case externalID == "" || strings.Contains(externalID, "/"):
+		server.writeError(w, errors.New("not found"), http.StatusNotFound)
+	case r.Method == http.MethodGet:
+		client, err := server.clientService.Get(r.Context(), tenant, externalID)
+		if err != nil {
+			server.writeServiceError(w, err)
+			return
+		}
+		server.writeJSON(w, client, http.StatusOK)
+	case r.Method == http.MethodPut:
+		body, ok := server.decodeClient(w, r)
+		if !ok {
+			return
+		}
+		body.ExternalID = externalID
+		client, err := server.clientService.Update(r.Context(), tenant, body)
+		if err != nil {
+			server.writeServiceError(w, err)
+			return
+		}
+		server.writeJSON(w, client, http.StatusOK)
+	case r.Method == http.MethodDelete:
+		if err := server.clientService.Delete(r.Context(), tenant, externalID); err != nil {
+			server.writeServiceError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		server.writeError(w, errors.New("method not allowed"), http.StatusMethodNotAllowed)
+	}
+}
+
+// decodeClient reads the client of the request body, it responds with 400 if the body is invalid.
+func (server *WebServer) decodeClient(w http.ResponseWriter, r *http.Request) (models.Client, bool) {
+	var body clientRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		server.writeError(w, e.BadRequestError{Message: "invalid client: " + err.Error()}, http.StatusBadRequest)
+		return models.Client{}, false
+	}
+	return models.Client{
+		ExternalID: body.ExternalID,
+		Username:   body.Username,
+		Phone:      body.Phone,
+		Email:      body.Email,
+	}, true
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	e "warehouse-system/errors"
+)
+
+func TestWriteServiceError(t *testing.T) {
+	server := &WebServer{log: log.New(ioutil.Discard, "", 0)}
+
+	tests := []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{"bad request", e.BadRequestError{Message: "invalid cursor"}, http.StatusBadRequest},
+		{"not found", e.NotFoundError{Message: "client c-1 not found"}, http.StatusNotFound},
+		{"conflict", e.ConflictError{Message: "client c-1 already exists"}, http.StatusConflict},
+		{"wrapped conflict", fmt.Errorf("create: %w", e.ConflictError{Message: "client c-1 already exists"}),
+			http.StatusConflict},
+		{"other", fmt.Errorf("connection refused"), http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			server.writeServiceError(recorder, test.err)
+			if recorder.Code != test.status {
+				t.Fatalf("status = %d, want %d", recorder.Code, test.status)
+			}
+			if test.status == http.StatusInternalServerError {
+				return
+			}
+			var body map[string]string
+			if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body["error"] != test.err.Error() {
+				t.Fatalf("error = %q, want %q", body["error"], test.err.Error())
+			}
+		})
+	}
+}
@@ -1,13 +1,18 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/brianvoe/gofakeit/v6"
 	"log"
 	"net/http"
+	"time"
 	e "warehouse-system/errors"
+	"warehouse-system/pkg/models"
+	"warehouse-system/pkg/queries"
+	"warehouse-system/pkg/queue"
 	"warehouse-system/pkg/services"
 )
 
@@ -16,91 +21,162 @@ type WebServer struct {
 	host           string
 	port           string
 	productService *services.ProductService
+	registry       *queries.Registry
+	// adminToken authenticates as an admin without an api key, it is disabled if empty
+	adminToken        string
+	deadLetterService *services.DeadLetterService
+	jobService        *services.JobService
+	rateLimitService  *services.RateLimitService
+	planService       *services.PlanService
+	apiKeyService     *services.APIKeyService
+	clientService     *services.ClientService
+	// jwtService accepts bearer tokens, they are rejected if it is nil
+	jwtService *services.JWTService
+	// shuttingDown is closed on shutdown to end event streams, which never complete by themselves
+	shuttingDown chan struct{}
 }
 
-func (server *WebServer) Run() {
-	http.HandleFunc("/products/bought", server.BoughtProductsQuantityHandler)
-	http.HandleFunc("/products/items/bought", server.BoughtItemsQuantityHandler)
-
-	addr := fmt.Sprintf("%s:%s", server.host, server.port)
-	server.log.Fatalln(http.ListenAndServe(addr, nil))
-}
-
-func (server *WebServer) BoughtProductsQuantityHandler(w http.ResponseWriter, r *http.Request) {
-	token := r.Header.Get("Token")
-	if token == "" {
-		err := e.BadRequestError{Message: "token must be provided"}
-		server.writeError(w, err, http.StatusBadRequest)
-		return
+// Run serves HTTP requests until ctx is cancelled, then it stops accepting connections
+// and waits up to shutdownTimeout for in-flight requests to complete.
+func (server *WebServer) Run(ctx context.Context, shutdownTimeout time.Duration) error {
+	httpServer := &http.Server{
+		Addr:    fmt.Sprintf("%s:%s", server.host, server.port),
+		Handler: server.mux(server.routes()),
 	}
-	server.log.Printf("Token: %s\n", token)
-
-	uid := gofakeit.LetterN(16)
-	server.log.Printf("Uid: %s\n", uid)
+	httpServer.RegisterOnShutdown(func() {
+		close(server.shuttingDown)
+	})
 
-	boughtProductsQuantity, err := server.productService.GetBoughtProducts(token, uid)
-	target := &e.MaxRetryCountExceededError{}
-	if errors.As(err, &target) {
-		w.WriteHeader(http.StatusTooManyRequests)
-		return
-	}
-	if err != nil {
-		server.log.Printf("Get bought products failed: %s\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- httpServer.ListenAndServe()
+	}()
 
-	data, err := json.Marshal(boughtProductsQuantity)
-	if err != nil {
-		server.log.Println("Unable to marshal boughtProductsQuantity struct.")
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
 	}
+	server.log.Println("Shutting down, draining in-flight requests.")
 
-	w.Header().Set("Content-Type", "application/json")
-	_, err = w.Write(data)
-	if err != nil {
-		server.log.Printf("Unable to send response: %s\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return httpServer.Shutdown(shutdownCtx)
 }
 
-func (server *WebServer) BoughtItemsQuantityHandler(w http.ResponseWriter, r *http.Request) {
-	token := r.Header.Get("Token")
-	if token == "" {
-		err := e.BadRequestError{Message: "token must be provided"}
-		server.writeError(w, err, http.StatusBadRequest)
-		return
+// mux passes requests to the handlers of the routes after authentication, scope checks
+// and, for limited routes, rate limiting.
+func (server *WebServer) mux(routes []route) *http.ServeMux {
+	mux := http.NewServeMux()
+	for _, route := range routes {
+		handler := route.handler
+		if route.limited {
+			handler = server.rateLimited(handler)
+		}
+		mux.HandleFunc(route.path, server.authenticated(server.requireScope(route, handler)))
 	}
-	server.log.Printf("Token: %s\n", token)
+	return mux
+}
 
-	uid := gofakeit.LetterN(16)
-	server.log.Printf("Uid: %s\n", uid)
+// route is an endpoint and the scopes a principal needs to call it.
+type route struct {
+	path string
+	// scope is required to read, writeScope to call the route with other methods than GET and HEAD
+	scope      string
+	writeScope string
+	handler    http.HandlerFunc
+	// limited routes count requests to the rate limit of the principal
+	limited bool
+}
 
-	boughtItemsQuantity, err := server.productService.GetBoughtItems(token, uid)
-	target := &e.MaxRetryCountExceededError{}
-	if errors.As(err, &target) {
-		w.WriteHeader(http.StatusTooManyRequests)
-		return
-	}
-	if err != nil {
-		server.log.Printf("Get bought items failed: %s\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+// requiredScope returns the scope a principal needs to call the route with the method.
+func (route route) requiredScope(method string) string {
+	if method == http.MethodGet || method == http.MethodHead {
+		return route.scope
 	}
+	return route.writeScope
+}
 
-	data, err := json.Marshal(boughtItemsQuantity)
-	if err != nil {
-		server.log.Println("Unable to marshal boughtItemsQuantity struct.")
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+// routes declares the endpoints of the web server with their scopes.
+func (server *WebServer) routes() []route {
+	var routes []route
+	for _, query := range server.registry.Queries() {
+		routes = append(routes, route{query.Path, models.ScopeReportsRead, models.ScopeReportsRead, server.ReportHandler(query), true})
 	}
+	return append(routes,
+		route{jobsPath, models.ScopeReportsRead, models.ScopeReportsRead, server.JobsHandler, true},
+		route{jobsPath + "/", models.ScopeReportsRead, models.ScopeReportsRead, server.JobsHandler, true},
+		route{clientsPath, models.ScopeInventoryRead, models.ScopeInventoryWrite, server.ClientsHandler, true},
+		route{clientsPath + "/", models.ScopeInventoryRead, models.ScopeInventoryWrite, server.ClientsHandler, true},
+		route{deadLettersPath, models.ScopeAdmin, models.ScopeAdmin, server.DeadLettersHandler, false},
+		route{deadLettersPath + "/", models.ScopeAdmin, models.ScopeAdmin, server.DeadLettersHandler, false},
+		route{"/admin/reports", models.ScopeAdmin, models.ScopeAdmin, server.ReportRefreshesHandler, false},
+		route{plansPath, models.ScopeAdmin, models.ScopeAdmin, server.PlansHandler, false},
+		route{plansPath + "/", models.ScopeAdmin, models.ScopeAdmin, server.PlansHandler, false},
+		route{tokensPath + "/", models.ScopeAdmin, models.ScopeAdmin, server.TokenPlanHandler, false},
+		route{apiKeysPath, models.ScopeAdmin, models.ScopeAdmin, server.APIKeysHandler, false},
+		route{apiKeysPath + "/", models.ScopeAdmin, models.ScopeAdmin, server.APIKeysHandler, false},
+	)
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	_, err = w.Write(data)
-	if err != nil {
-		server.log.Printf("Unable to send response: %s\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
+func (server *WebServer) ReportHandler(query *queries.Query) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal := principalFrom(r)
+		token := principal.ID
+		server.log.Printf("Principal: %s\n", token)
+
+		var params queries.Params
+		if query.ParseParams != nil {
+			var err error
+			if params, err = query.ParseParams(r.URL.Query()); err != nil {
+				server.writeError(w, err, http.StatusBadRequest)
+				return
+			}
+		}
+
+		// long reports may not be computed within the subscribe timeout, the client polls the job instead
+		if r.URL.Query().Get("async") == "true" {
+			server.createJob(w, r, query, params, principal)
+			return
+		}
+
+		uid := gofakeit.LetterN(16)
+		server.log.Printf("Uid: %s\n", uid)
+
+		trace := queue.NewTraceContext(r.Header.Get("traceparent"), r.Header.Get("tracestate"))
+
+		report, cacheStatus, err := server.productService.GetReport(query, principal.Tenant, params, token, uid, trace)
+		var maxRetryErr e.MaxRetryCountExceededError
+		if errors.As(err, &maxRetryErr) {
+			server.writeMaxRetryCountExceeded(w, err, token)
+			return
+		}
+		var unknownQueryErr e.UnknownQueryError
+		var badRequestErr e.BadRequestError
+		if errors.As(err, &unknownQueryErr) || errors.As(err, &badRequestErr) {
+			server.writeError(w, err, http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			server.log.Printf("Get %s failed: %s\n", query.Name, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		data, err := json.Marshal(report)
+		if err != nil {
+			server.log.Printf("Unable to marshal %s report.\n", query.Name)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Cache", string(cacheStatus))
+		_, err = w.Write(data)
+		if err != nil {
+			server.log.Printf("Unable to send response: %s\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
 	}
 }
 
@@ -121,7 +197,11 @@ func (server *WebServer) writeError(w http.ResponseWriter, err error, statusCode
 	}
 }
 
-func NewWebServer(host, port string, log *log.Logger, service *services.ProductService) *WebServer {
+func NewWebServer(host, port string, log *log.Logger, service *services.ProductService,
+	registry *queries.Registry, adminToken string, deadLetterService *services.DeadLetterService,
+	jobService *services.JobService, rateLimitService *services.RateLimitService,
+	planService *services.PlanService, apiKeyService *services.APIKeyService, jwtService *services.JWTService,
+	clientService *services.ClientService) *WebServer {
 	log.SetPrefix("[web server] ")
 
 	return &WebServer{
@@ -129,5 +209,16 @@ func NewWebServer(host, port string, log *log.Logger, service *services.ProductS
 		host:           host,
 		port:           port,
 		productService: service,
+		registry:       registry,
+
+		adminToken:        adminToken,
+		deadLetterService: deadLetterService,
+		jobService:        jobService,
+		rateLimitService:  rateLimitService,
+		planService:       planService,
+		apiKeyService:     apiKeyService,
+		jwtService:        jwtService,
+		clientService:     clientService,
+		shuttingDown:      make(chan struct{}),
 	}
 }
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"warehouse-system/config"
+	"warehouse-system/pkg/models"
+	"warehouse-system/pkg/queries"
+	"warehouse-system/pkg/ratelimit"
+	"warehouse-system/pkg/redis"
+	"warehouse-system/pkg/services"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+const (
+	testAdminToken = "admin-token"
+	testTenant     = "tenant-a"
+)
+
+// testKeys are API keys of clients, each granted a single scope. The admin-scoped key
+// stands for a key created before the admin scope was reserved to the admin token.
+var testKeys = map[string]string{
+	models.ScopeReportsRead:    "reports-read-key",
+	models.ScopeInventoryRead:  "inventory-read-key",
+	models.ScopeInventoryWrite: "inventory-write-key",
+	models.ScopeAdmin:          "client-admin-key",
+}
+
+const invalidKey = "invalid-key"
+
+// newTestServer returns a web server backed by an in-memory redis with the test keys cached,
+// so authentication needs no database.
+func newTestServer(t *testing.T) *WebServer {
+	t.Helper()
+	mr := miniredis.RunT(t)
+
+	logger := log.New(ioutil.Discard, "", 0)
+	appConfig := config.NewAppConfig()
+	appConfig.RedisHost = mr.Host()
+	appConfig.RedisPort = mr.Port()
+	// every route is called several times by the same principal and address
+	appConfig.RateLimitRequests = 10000
+	appConfig.RateLimitBurst = 10000
+	appConfig.AuthFailureLimit = 10000
+
+	ctx := context.Background()
+	redisClient := redis.NewRedisClient(ctx, logger, appConfig)
+	if redisClient == nil {
+		t.Fatal("unable to connect to redis")
+	}
+	t.Cleanup(redisClient.Close)
+
+	newLimiter := func(prefix string) *ratelimit.Limiter {
+		limiter, err := redisClient.NewLimiter(appConfig.RateLimitAlgorithm, prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return limiter
+	}
+	planService := services.NewPlanService(logger, appConfig, redisClient)
+	rateLimitService := services.NewRateLimitService(logger, appConfig, planService, newLimiter(ratelimit.Requests),
+		newLimiter(ratelimit.Queries), newLimiter(ratelimit.AuthFailures), redisClient.NewQuotas())
+	apiKeyService := services.NewAPIKeyService(logger, appConfig, redisClient, nil)
+
+	for scope, key := range testKeys {
+		clientID := "client-" + scope
+		principal := &models.Principal{
+			ID:       services.ClientPrincipalID(testTenant, clientID),
+			ClientID: clientID,
+			Tenant:   testTenant,
+			KeyID:    1,
+			Scopes:   []string{scope},
+		}
+		if err := redisClient.CacheAuthentication(testKeyHash(key), principal, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := redisClient.CacheAuthentication(testKeyHash(invalidKey), nil, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	return NewWebServer("", "", logger, nil, queries.NewReportsRegistry(appConfig), testAdminToken, nil, nil,
+		rateLimitService, planService, apiKeyService, nil, nil)
+}
+
+// testKeyHash is the hash API keys are cached under.
+func testKeyHash(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+func TestRoutesRequireScopes(t *testing.T) {
+	server := newTestServer(t)
+
+	// handlers are stubbed, the test covers authentication and scope checks only
+	var called bool
+	routes := server.routes()
+	for i := range routes {
+		routes[i].handler = func(w http.ResponseWriter, r *http.Request) {
+			called = true
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+	mux := server.mux(routes)
+
+	serve := func(method, path string, header http.Header) int {
+		called = false
+		r := httptest.NewRequest(method, path, nil)
+		for name, values := range header {
+			r.Header[name] = values
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		if w.Code == http.StatusOK && !called {
+			t.Fatalf("%s %s responded 200 without calling the handler", method, path)
+		}
+		return w.Code
+	}
+	withKey := func(key string) http.Header {
+		return http.Header{"Token": {key}}
+	}
+
+	covered := make(map[string]bool)
+	for _, route := range routes {
+		for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete} {
+			scope := route.requiredScope(method)
+			covered[scope] = true
+			t.Run(method+" "+route.path, func(t *testing.T) {
+				if scope == "" {
+					t.Fatal("the route requires no scope")
+				}
+				if code := serve(method, route.path, nil); code != http.StatusUnauthorized {
+					t.Errorf("without credentials = %d, want 401", code)
+				}
+				if code := serve(method, route.path, withKey(invalidKey)); code != http.StatusUnauthorized {
+					t.Errorf("with an invalid key = %d, want 401", code)
+				}
+				bearer := http.Header{"Authorization": {"Bearer token"}}
+				if code := serve(method, route.path, bearer); code != http.StatusUnauthorized {
+					t.Errorf("with a bearer token = %d, want 401", code)
+				}
+
+				for keyScope, key := range testKeys {
+					code := serve(method, route.path, withKey(key))
+					// client keys never pass admin routes, even with the admin scope
+					allowed := keyScope == scope && scope != models.ScopeAdmin
+					if allowed && code != http.StatusOK {
+						t.Errorf("with a %s key = %d, want 200", keyScope, code)
+					}
+					if !allowed && code != http.StatusForbidden {
+						t.Errorf("with a %s key = %d, want 403", keyScope, code)
+					}
+				}
+
+				code := serve(method, route.path, withKey(testAdminToken))
+				if scope == models.ScopeAdmin && code != http.StatusOK {
+					t.Errorf("with the admin token = %d, want 200", code)
+				}
+				if scope != models.ScopeAdmin && code != http.StatusForbidden {
+					t.Errorf("with the admin token = %d, want 403", code)
+				}
+			})
+		}
+	}
+
+	for _, scope := range []string{models.ScopeReportsRead, models.ScopeInventoryRead, models.ScopeInventoryWrite,
+		models.ScopeAdmin} {
+		if !covered[scope] {
+			t.Errorf("no route requires the %s scope", scope)
+		}
+	}
+}
+
+func TestClientRoutesSplitScopesByMethod(t *testing.T) {
+	server := newTestServer(t)
+
+	for _, route := range server.routes() {
+		if route.path != clientsPath && route.path != clientsPath+"/" {
+			continue
+		}
+		if scope := route.requiredScope(http.MethodGet); scope != models.ScopeInventoryRead {
+			t.Errorf("GET %s requires %s, want %s", route.path, scope, models.ScopeInventoryRead)
+		}
+		for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodDelete} {
+			if scope := route.requiredScope(method); scope != models.ScopeInventoryWrite {
+				t.Errorf("%s %s requires %s, want %s", method, route.path, scope, models.ScopeInventoryWrite)
+			}
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	e "warehouse-system/errors"
+	"warehouse-system/pkg/models"
+	"warehouse-system/pkg/queries"
+	"warehouse-system/pkg/queue"
+)
+
+const jobsPath = "/jobs"
+
+const (
+	// jobPositionInterval is how often the position of a queued job is checked for the event stream
+	jobPositionInterval = time.Second
+	// keepAliveInterval keeps idle event streams open behind proxies
+	keepAliveInterval = 15 * time.Second
+)
+
+// jobRequest is the body of POST /jobs. Params are the query arguments of the report endpoint,
+// e.g. {"as_of": "2026-10-17T12:00:00Z"}.
+type jobRequest struct {
+	Query  string            `json:"query"`
+	Params map[string]string `json:"params"`
+}
+
+// JobsHandler serves:
+//
+//	POST /jobs             - request a report asynchronously, {"query": "products:expired", "params": {...}}
+//	GET  /jobs/{id}        - poll the job status, the result is returned when the job has succeeded
+//	GET  /jobs/{id}/events - stream the job events as server-sent events until the job is completed
+func (server *WebServer) JobsHandler(w http.ResponseWriter, r *http.Request) {
+	principal := principalFrom(r)
+	token := principal.ID
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, jobsPath), "/")
+	parts := strings.Split(path, "/")
+	switch {
+	case path == "" && r.Method == http.MethodPost:
+		var body jobRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			server.writeError(w, e.BadRequestError{Message: "invalid job request: " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+		query, ok := server.registry.Get(body.Query)
+		if !ok {
+			server.writeError(w, e.UnknownQueryError{Name: body.Query}, http.StatusBadRequest)
+			return
+		}
+		params, err := jobParams(query, body.Params)
+		if err != nil {
+			server.writeError(w, err, http.StatusBadRequest)
+			return
+		}
+		server.createJob(w, r, query, params, principal)
+	case path != "" && len(parts) == 1 && r.Method == http.MethodGet:
+		job, err := server.jobService.GetJob(parts[0], token)
+		if err != nil {
+			server.writeServiceError(w, err)
+			return
+		}
+		server.writeJSON(w, job, http.StatusOK)
+	case len(parts) == 2 && parts[1] == "events" && r.Method == http.MethodGet:
+		server.streamJobEvents(w, r, parts[0], token)
+	default:
+		server.writeError(w, errors.New("not found"), http.StatusNotFound)
+	}
+}
+
+// jobParams validates and normalizes params of a job like the report endpoint does with its
+// query arguments, so jobs and report requests share caches.
+func jobParams(query *queries.Query, values map[string]string) (queries.Params, error) {
+	if query.ParseParams == nil {
+		if len(values) != 0 {
+			return queries.Params{}, e.BadRequestError{Message: fmt.Sprintf("query %s takes no params", query.Name)}
+		}
+		return queries.Params{}, nil
+	}
+	args := url.Values{}
+	for name, value := range values {
+		args.Set(name, value)
+	}
+	return query.ParseParams(args)
+}
+
+// createJob responds with 202 Accepted and the job location to poll.
+func (server *WebServer) createJob(w http.ResponseWriter, r *http.Request, query *queries.Query,
+	params queries.Params, principal *models.Principal) {
+	trace := queue.NewTraceContext(r.Header.Get("traceparent"), r.Header.Get("tracestate"))
+	job, err := server.jobService.CreateJob(query, principal.Tenant, params, principal.ID, trace)
+	if err != nil {
+		server.writeServiceError(w, err)
+		return
+	}
+	w.Header().Set("Location", jobsPath+"/"+job.ID)
+	server.writeJSON(w, job, http.StatusAccepted)
+}
+
+// streamJobEvents sends the current job state and then the job events as they are published.
+// The position of a queued job is checked periodically, the stream ends when the job is completed.
+func (server *WebServer) streamJobEvents(w http.ResponseWriter, r *http.Request, id, token string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		server.writeError(w, errors.New("streaming is not supported"), http.StatusInternalServerError)
+		return
+	}
+
+	job, subscription, err := server.jobService.SubscribeEvents(id, token)
+	if err != nil {
+		server.writeServiceError(w, err)
+		return
+	}
+	defer subscription.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	event := job.Event()
+	if event.Status == queue.JobQueued {
+		event.Position = server.jobPosition(job)
+	}
+	if !server.writeEvent(w, flusher, event) || event.Completed() {
+		return
+	}
+	status, position := event.Status, event.Position
+
+	messages := subscription.Messages()
+	ticker := time.NewTicker(jobPositionInterval)
+	defer ticker.Stop()
+	lastWrite := time.Now()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-server.shuttingDown:
+			return
+		case raw, ok := <-messages:
+			if !ok {
+				return
+			}
+			event, err := queue.DecodeJobEvent(raw)
+			if err != nil {
+				server.log.Printf("Unable to decode job %s event: %s\n", id, err)
+				continue
+			}
+			if !server.writeEvent(w, flusher, event) || event.Completed() {
+				return
+			}
+			status, position, lastWrite = event.Status, nil, time.Now()
+		case <-ticker.C:
+			if status == queue.JobQueued {
+				// the request written to the queue changes when the job is throttled, so it is read again
+				if job, err = server.jobService.GetJob(id, token); err != nil {
+					server.log.Printf("Unable to get job %s: %s\n", id, err)
+					return
+				}
+				current := server.jobPosition(job)
+				if current != nil && (position == nil || *current != *position) {
+					position, lastWrite = current, time.Now()
+					event := queue.JobEvent{Event: queue.JobPosition, Status: status, Position: position, At: lastWrite}
+					if !server.writeEvent(w, flusher, event) {
+						return
+					}
+				}
+			}
+			if time.Since(lastWrite) >= keepAliveInterval {
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+				flusher.Flush()
+				lastWrite = time.Now()
+			}
+		}
+	}
+}
+
+func (server *WebServer) jobPosition(job *queue.Job) *int64 {
+	position, err := server.jobService.GetQueuePosition(job)
+	if err != nil {
+		server.log.Printf("Unable to get job %s queue position: %s\n", job.ID, err)
+	}
+	return position
+}
+
+// writeEvent sends the event to the client, it returns false if the client is gone.
+func (server *WebServer) writeEvent(w http.ResponseWriter, flusher http.Flusher, event queue.JobEvent) bool {
+	data, err := event.Encode()
+	if err != nil {
+		server.log.Printf("Unable to encode job event: %s\n", err)
+		return true
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Event, data); err != nil {
+		server.log.Printf("Unable to send job event: %s\n", err)
+		return false
+	}
+	flusher.Flush()
+	return true
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"errors"
+	"testing"
+	"time"
+	"warehouse-system/config"
+	e "warehouse-system/errors"
+	"warehouse-system/pkg/queries"
+)
+
+func TestJobParams(t *testing.T) {
+	registry := queries.NewReportsRegistry(config.NewAppConfig())
+	expired, _ := registry.Get("products:expired")
+	bought, _ := registry.Get("products:bought")
+	asOf := time.Date(2026, 10, 17, 12, 0, 29, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		query  *queries.Query
+		values map[string]string
+		asOf   *time.Time
+		valid  bool
+	}{
+		{"no params", expired, nil, nil, true},
+		{"as_of", expired, map[string]string{"as_of": "2026-10-17T12:00:29Z"}, &asOf, true},
+		{"as_of with offset", expired, map[string]string{"as_of": "2026-10-17T14:00:29+02:00"}, &asOf, true},
+		{"fractional as_of", expired, map[string]string{"as_of": "2026-10-17T12:00:29.5Z"}, nil, false},
+		{"invalid as_of", expired, map[string]string{"as_of": "yesterday"}, nil, false},
+		{"report without params", bought, nil, nil, true},
+		{"params of a report without params", bought, map[string]string{"as_of": "2026-10-17T12:00:29Z"}, nil, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			params, err := jobParams(test.query, test.values)
+			if !test.valid {
+				var badRequestErr e.BadRequestError
+				if !errors.As(err, &badRequestErr) {
+					t.Fatalf("jobParams = %v, want a bad request error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("jobParams = %s", err)
+			}
+			if (params.AsOf == nil) != (test.asOf == nil) || (test.asOf != nil && !params.AsOf.Equal(*test.asOf)) {
+				t.Fatalf("jobParams as_of = %v, want %v", params.AsOf, test.asOf)
+			}
+		})
+	}
+}
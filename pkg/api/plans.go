@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	e "warehouse-system/errors"
+	"warehouse-system/pkg/ratelimit"
+)
+
+const (
+	plansPath  = "/admin/plans"
+	tokensPath = "/admin/tokens"
+)
+
+// PlansHandler serves:
+//
+//	GET    /admin/plans        - list quota plans
+//	GET    /admin/plans/{name} - get a quota plan
+//	PUT    /admin/plans/{name} - create or replace a quota plan
+//	DELETE /admin/plans/{name} - delete a quota plan, its tokens use the default plan
+func (server *WebServer) PlansHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, plansPath), "/")
+
+	switch {
+	case name == "" && r.Method == http.MethodGet:
+		plans, err := server.planService.List()
+		if err != nil {
+			server.writeServiceError(w, err)
+			return
+		}
+		server.writeJSON(w, plans, http.StatusOK)
+	case name == "" || strings.Contains(name, "/"):
+		server.writeError(w, errors.New("not found"), http.StatusNotFound)
+	case r.Method == http.MethodGet:
+		plan, err := server.planService.Get(name)
+		if err != nil {
+			server.writeServiceError(w, err)
+			return
+		}
+		server.writeJSON(w, plan, http.StatusOK)
+	case r.Method == http.MethodPut:
+		var plan ratelimit.Plan
+		if err := json.NewDecoder(r.Body).Decode(&plan); err != nil {
+			server.writeError(w, e.BadRequestError{Message: "invalid plan: " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+		plan.Name = name
+		if err := server.planService.Save(plan); err != nil {
+			server.writeServiceError(w, err)
+			return
+		}
+		server.writeJSON(w, plan, http.StatusOK)
+	case r.Method == http.MethodDelete:
+		if err := server.planService.Delete(name); err != nil {
+			server.writeServiceError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		server.writeError(w, errors.New("method not allowed"), http.StatusMethodNotAllowed)
+	}
+}
+
+// TokenPlanHandler serves the plans of principals, a client is identified as client:{tenant}:{external id}:
+//
+//	GET    /admin/tokens/{principal}/plan - get the plan used by a principal
+//	PUT    /admin/tokens/{principal}/plan - assign a plan to a principal, {"plan": "partners"}
+//	DELETE /admin/tokens/{principal}/plan - make a principal use the default plan
+func (server *WebServer) TokenPlanHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, tokensPath), "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] != "plan" {
+		server.writeError(w, errors.New("not found"), http.StatusNotFound)
+		return
+	}
+	token := parts[0]
+
+	switch r.Method {
+	case http.MethodGet:
+		plan, err := server.planService.GetPlan(token)
+		if err != nil {
+			server.writeServiceError(w, err)
+			return
+		}
+		server.writeJSON(w, plan, http.StatusOK)
+	case http.MethodPut:
+		var body struct {
+			Plan string `json:"plan"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Plan == "" {
+			server.writeError(w, e.BadRequestError{Message: "plan must be provided"}, http.StatusBadRequest)
+			return
+		}
+		if err := server.planService.Assign(token, body.Plan); err != nil {
+			server.writeServiceError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if err := server.planService.Unassign(token); err != nil {
+			server.writeServiceError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		server.writeError(w, errors.New("method not allowed"), http.StatusMethodNotAllowed)
+	}
+}
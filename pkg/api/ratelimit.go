@@ -0,0 +1,69 @@
+package api
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+	e "warehouse-system/errors"
+	"warehouse-system/pkg/ratelimit"
+)
+
+// rateLimited counts requests of the authenticated principal and rejects them with 429
+// once the principal has exceeded its rate limit.
+func (server *WebServer) rateLimited(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		decision, err := server.rateLimitService.Allow(principalFrom(r).ID)
+		var rateLimitErr e.RateLimitExceededError
+		if errors.As(err, &rateLimitErr) {
+			server.writeTooManyRequests(w, err, decision)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		setRateLimitHeaders(w, decision)
+		next(w, r)
+	}
+}
+
+// writeMaxRetryCountExceeded responds to a request that query workers have given up on,
+// the client is told to retry when the query limit of the token allows it.
+func (server *WebServer) writeMaxRetryCountExceeded(w http.ResponseWriter, err error, token string) {
+	decision, stateErr := server.rateLimitService.QueryLimitState(token)
+	if stateErr != nil {
+		server.log.Printf("Unable to get query limit state: %s\n", stateErr)
+	}
+	// the concurrency limit may be exceeded even if the rate limit allows the query
+	decision.Allowed = false
+	if decision.RetryAfter < time.Second {
+		decision.RetryAfter = time.Second
+	}
+	server.writeTooManyRequests(w, err, decision)
+}
+
+// writeTooManyRequests responds with 429 and the headers telling when to retry.
+func (server *WebServer) writeTooManyRequests(w http.ResponseWriter, err error, decision ratelimit.Decision) {
+	setRateLimitHeaders(w, decision)
+	server.writeError(w, err, http.StatusTooManyRequests)
+}
+
+// setRateLimitHeaders sets the RateLimit headers of the IETF draft and Retry-After
+// if the request isn't allowed. Durations are sent in seconds.
+func setRateLimitHeaders(w http.ResponseWriter, decision ratelimit.Decision) {
+	if !decision.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(seconds(decision.RetryAfter)))
+	}
+	if decision.Limit == 0 {
+		return
+	}
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(decision.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(seconds(decision.ResetAfter)))
+}
+
+func seconds(duration time.Duration) int {
+	return int(math.Ceil(duration.Seconds()))
+}
@@ -0,0 +1,175 @@
+package jwks
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// jsonWebKey is a public key of a JWKS file, RFC 7517.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// KeySet is a set of public keys loaded from a local JWKS file. The keys are reloaded
+// when the file changes; if the new file is invalid, the loaded keys are kept.
+type KeySet struct {
+	log  *log.Logger
+	path string
+
+	mu   sync.RWMutex
+	keys map[string]interface{}
+}
+
+// Key returns the RSA or ECDSA public key with the key id.
+func (set *KeySet) Key(kid string) (interface{}, bool) {
+	set.mu.RLock()
+	defer set.mu.RUnlock()
+	key, ok := set.keys[kid]
+	return key, ok
+}
+
+// Watch reloads the keys when the file changes until ctx is cancelled. The directory is watched,
+// so the file may be replaced by a rename or a symlink swap.
+func (set *KeySet) Watch(ctx context.Context) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		set.log.Printf("Unable to watch JWKS file: %s\n", err)
+		return
+	}
+	defer watcher.Close()
+	if err := watcher.Add(filepath.Dir(set.path)); err != nil {
+		set.log.Printf("Unable to watch JWKS file: %s\n", err)
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if err := set.load(); err != nil {
+				set.log.Printf("Unable to reload JWKS file, keeping loaded keys: %s\n", err)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			set.log.Printf("JWKS file watcher failed: %s\n", err)
+		}
+	}
+}
+
+func (set *KeySet) load() error {
+	data, err := os.ReadFile(set.path)
+	if err != nil {
+		return err
+	}
+	var file struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("invalid JWKS file: %s", err)
+	}
+
+	keys := make(map[string]interface{}, len(file.Keys))
+	for _, jwk := range file.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return fmt.Errorf("invalid key %s: %s", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = key
+	}
+
+	set.mu.Lock()
+	set.keys = keys
+	set.mu.Unlock()
+	set.log.Printf("%d JWKS keys are loaded.\n", len(keys))
+	return nil
+}
+
+func (jwk jsonWebKey) publicKey() (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+		x, err := decodeInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve %s", jwk.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", jwk.Kty)
+}
+
+func decodeInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty key parameter")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// NewKeySet loads the keys of the JWKS file, call Watch to reload them on changes.
+func NewKeySet(log *log.Logger, path string) (*KeySet, error) {
+	set := &KeySet{
+		log:  log,
+		path: path,
+	}
+	if err := set.load(); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
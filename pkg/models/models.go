@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type BoughtProductsQuantity struct {
 	Manufacturer           string
 	BoughtProductsQuantity int
@@ -9,3 +11,101 @@ type BoughtItemsQuantity struct {
 	Manufacturer        string
 	BoughtItemsQuantity int
 }
+
+type ExpiredProductsQuantity struct {
+	Manufacturer            string
+	ExpiredProductsQuantity int
+}
+
+type OrderedProductItemsQuantity struct {
+	ExternalID           string
+	Product              string
+	Manufacturer         string
+	OrderedItemsQuantity int
+	ClientsQuantity      int
+}
+
+type ReportRefresh struct {
+	Report      string
+	CacheKey    string
+	RefreshedAt time.Time
+	Duration    time.Duration
+}
+
+// Client is a customer of a tenant. The external id and the phone are unique within the tenant.
+type Client struct {
+	ID         int    `json:"-"`
+	ExternalID string `json:"external_id"`
+	Username   string `json:"username"`
+	Phone      string `json:"phone"`
+	// Email is empty if the client has none
+	Email string `json:"email"`
+}
+
+// ClientsPage is a page of clients, NextCursor is empty on the last page.
+type ClientsPage struct {
+	Clients []Client `json:"clients"`
+	// NextCursor is empty on the last page
+	NextCursor string `json:"next_cursor"`
+}
+
+// DefaultTenant owns the data created before tenants were introduced.
+const DefaultTenant = "default"
+
+// Scopes of API keys, a route requires one of them. The admin scope is granted
+// to the admin token only, as admin routes see data of all tenants.
+const (
+	ScopeReportsRead    = "reports:read"
+	ScopeInventoryRead  = "inventory:read"
+	ScopeInventoryWrite = "inventory:write"
+	ScopeAdmin          = "admin"
+)
+
+// ValidScope reports whether the scope is known.
+func ValidScope(scope string) bool {
+	switch scope {
+	case ScopeReportsRead, ScopeInventoryRead, ScopeInventoryWrite, ScopeAdmin:
+		return true
+	}
+	return false
+}
+
+// APIKey is a key a client authenticates with. Only the hash of the key is stored,
+// the prefix tells keys of a client apart.
+type APIKey struct {
+	ID        int
+	ClientID  string
+	Tenant    string
+	Name      string
+	Prefix    string
+	Hash      string `json:"-"`
+	Scopes    []string
+	CreatedAt time.Time
+	ExpiresAt *time.Time
+	RevokedAt *time.Time
+}
+
+// Active reports whether the key is neither revoked nor expired.
+func (key APIKey) Active(now time.Time) bool {
+	return key.RevokedAt == nil && (key.ExpiresAt == nil || now.Before(*key.ExpiresAt))
+}
+
+// Principal is the authenticated caller of the web server.
+type Principal struct {
+	// ID identifies the caller in rate limits, quota plans, jobs and queue messages
+	ID       string
+	ClientID string
+	// Tenant is the tenant of the client, reports are computed from its data only
+	Tenant string
+	KeyID  int
+	Scopes []string
+}
+
+func (principal *Principal) HasScope(scope string) bool {
+	for _, granted := range principal.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
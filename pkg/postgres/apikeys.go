@@ -0,0 +1,120 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"github.com/lib/pq"
+	"time"
+	"warehouse-system/pkg/models"
+)
+
+// CreateAPIKey stores the key of the client of the tenant with the external id. It returns nil
+// if there is no such client.
+func (client *Client) CreateAPIKey(ctx context.Context, key models.APIKey) (*models.APIKey, error) {
+	queryStr := `
+		INSERT INTO api_keys (client_id, name, prefix, key_hash, expires_at, scopes)
+		SELECT id, $3, $4, $5, $6, $7 FROM clients WHERE tenant_id=$1 AND external_id=$2
+		RETURNING id, created_at;`
+
+	row := client.db.QueryRowContext(ctx, queryStr,
+		key.Tenant, key.ClientID, key.Name, key.Prefix, key.Hash, key.ExpiresAt, pq.Array(key.Scopes))
+	err := row.Scan(&key.ID, &key.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		client.log.Printf("unable to insert api key: %s\n", err)
+		return nil, err
+	}
+	return &key, nil
+}
+
+// GetAPIKeyByHash returns nil if there is no key with the hash.
+func (client *Client) GetAPIKeyByHash(ctx context.Context, hash string) (*models.APIKey, error) {
+	queryStr := `
+		SELECT api_keys.id, clients.external_id, clients.tenant_id, api_keys.name, api_keys.prefix, api_keys.key_hash, api_keys.scopes,
+		api_keys.created_at, api_keys.expires_at, api_keys.revoked_at
+		FROM api_keys JOIN clients ON api_keys.client_id=clients.id
+		WHERE api_keys.key_hash=$1;`
+
+	key, err := scanAPIKey(client.db.QueryRowContext(ctx, queryStr, hash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		client.log.Printf("unable to query api key: %s\n", err)
+		return nil, err
+	}
+	return key, nil
+}
+
+// GetAPIKeys returns keys of clients of the tenant with the external id. Empty filters match
+// all tenants or all clients.
+func (client *Client) GetAPIKeys(ctx context.Context, tenant, clientID string) ([]models.APIKey, error) {
+	queryStr := `
+		SELECT api_keys.id, clients.external_id, clients.tenant_id, api_keys.name, api_keys.prefix, api_keys.key_hash, api_keys.scopes,
+		api_keys.created_at, api_keys.expires_at, api_keys.revoked_at
+		FROM api_keys JOIN clients ON api_keys.client_id=clients.id
+		WHERE ($1='' OR clients.tenant_id=$1) AND ($2='' OR clients.external_id=$2)
+		ORDER BY api_keys.id;`
+
+	rows, err := client.db.QueryContext(ctx, queryStr, tenant, clientID)
+	if err != nil {
+		client.log.Printf("unable to query: %s\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			client.log.Printf("unable to scan result: %s\n", err)
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey marks the key revoked and returns its hash. It returns nil if there is no active key with the id.
+func (client *Client) RevokeAPIKey(ctx context.Context, id int) (*string, error) {
+	queryStr := `
+		UPDATE api_keys SET revoked_at=now()
+		WHERE id=$1 AND revoked_at IS NULL
+		RETURNING key_hash;`
+
+	var hash string
+	err := client.db.QueryRowContext(ctx, queryStr, id).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		client.log.Printf("unable to revoke api key: %s\n", err)
+		return nil, err
+	}
+	return &hash, nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAPIKey(row scanner) (*models.APIKey, error) {
+	var (
+		key       models.APIKey
+		expiresAt sql.NullTime
+		revokedAt sql.NullTime
+	)
+	if err := row.Scan(&key.ID, &key.ClientID, &key.Tenant, &key.Name, &key.Prefix, &key.Hash, pq.Array(&key.Scopes),
+		&key.CreatedAt, &expiresAt, &revokedAt); err != nil {
+		return nil, err
+	}
+	key.ExpiresAt = nullTime(expiresAt)
+	key.RevokedAt = nullTime(revokedAt)
+	return &key, nil
+}
+
+func nullTime(value sql.NullTime) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+	return &value.Time
+}
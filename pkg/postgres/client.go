@@ -12,8 +12,9 @@ import (
 )
 
 type Client struct {
-	log *log.Logger
-	db  *sql.DB
+	log     *log.Logger
+	db      *sql.DB
+	connStr string
 }
 
 func (client *Client) Close() {
@@ -54,7 +55,8 @@ func NewClient(log *log.Logger, config *config.AppConfig) *Client {
 	}
 
 	return &Client{
-		db:  db,
-		log: log,
+		db:      db,
+		log:     log,
+		connStr: connStr,
 	}
 }
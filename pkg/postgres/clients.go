@@ -0,0 +1,191 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	e "warehouse-system/errors"
+	"warehouse-system/pkg/models"
+)
+
+// uniqueViolation is the postgres error code of a unique constraint violation.
+const uniqueViolation = "23505"
+
+// CreateClient stores the client of the tenant. It returns a conflict error if the external id
+// or the phone is taken by another client of the tenant.
+func (client *Client) CreateClient(ctx context.Context, tenant string, c models.Client) (*models.Client, error) {
+	queryStr := `
+		INSERT INTO clients (tenant_id, external_id, username, phone, email)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id;`
+
+	err := client.db.QueryRowContext(ctx, queryStr, tenant, c.ExternalID, c.Username, c.Phone,
+		nullString(c.Email)).Scan(&c.ID)
+	if err != nil {
+		if conflict := clientConflict(err, c); conflict != nil {
+			return nil, conflict
+		}
+		client.log.Printf("unable to insert client: %s\n", err)
+		return nil, err
+	}
+	return &c, nil
+}
+
+// GetClient returns nil if the tenant has no client with the external id.
+func (client *Client) GetClient(ctx context.Context, tenant, externalID string) (*models.Client, error) {
+	queryStr := `
+		SELECT id, external_id, username, phone, email FROM clients
+		WHERE tenant_id=$1 AND external_id=$2;`
+
+	c, err := scanClient(client.db.QueryRowContext(ctx, queryStr, tenant, externalID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		client.log.Printf("unable to query client: %s\n", err)
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetClientTenants returns tenants having a client with the external id, only the tenant is
+// matched if it isn't empty. At most two tenants are returned, enough to tell the id is ambiguous.
+func (client *Client) GetClientTenants(ctx context.Context, externalID, tenant string) ([]string, error) {
+	queryStr := `
+		SELECT tenant_id FROM clients
+		WHERE external_id=$1 AND ($2='' OR tenant_id=$2)
+		ORDER BY tenant_id LIMIT 2;`
+
+	rows, err := client.db.QueryContext(ctx, queryStr, externalID, tenant)
+	if err != nil {
+		client.log.Printf("unable to query: %s\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tenants []string
+	for rows.Next() {
+		var tenant string
+		if err := rows.Scan(&tenant); err != nil {
+			client.log.Printf("unable to scan result: %s\n", err)
+			return nil, err
+		}
+		tenants = append(tenants, tenant)
+	}
+	return tenants, rows.Err()
+}
+
+// GetClients returns up to limit clients of the tenant with ids greater than afterID, ordered by id.
+func (client *Client) GetClients(ctx context.Context, tenant string, afterID, limit int) ([]models.Client, error) {
+	queryStr := `
+		SELECT id, external_id, username, phone, email FROM clients
+		WHERE tenant_id=$1 AND id>$2
+		ORDER BY id LIMIT $3;`
+
+	rows, err := client.db.QueryContext(ctx, queryStr, tenant, afterID, limit)
+	if err != nil {
+		client.log.Printf("unable to query: %s\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []models.Client{}
+	for rows.Next() {
+		c, err := scanClient(rows)
+		if err != nil {
+			client.log.Printf("unable to scan result: %s\n", err)
+			return nil, err
+		}
+		clients = append(clients, *c)
+	}
+	return clients, rows.Err()
+}
+
+// UpdateClient replaces the username, phone and email of the client of the tenant with the external id.
+// It returns nil if there is no such client and a conflict error if the phone is taken.
+func (client *Client) UpdateClient(ctx context.Context, tenant string, c models.Client) (*models.Client, error) {
+	queryStr := `
+		UPDATE clients SET username=$3, phone=$4, email=$5
+		WHERE tenant_id=$1 AND external_id=$2
+		RETURNING id;`
+
+	err := client.db.QueryRowContext(ctx, queryStr, tenant, c.ExternalID, c.Username, c.Phone,
+		nullString(c.Email)).Scan(&c.ID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		if conflict := clientConflict(err, c); conflict != nil {
+			return nil, conflict
+		}
+		client.log.Printf("unable to update client: %s\n", err)
+		return nil, err
+	}
+	return &c, nil
+}
+
+// DeleteClient deletes the client of the tenant with its orders and api keys. It returns hashes
+// of the deleted keys, or false if there is no such client.
+func (client *Client) DeleteClient(ctx context.Context, tenant, externalID string) (bool, []string, error) {
+	// api keys are deleted by the cascade at the end of the statement, so they are still selected
+	queryStr := `
+		WITH deleted AS (DELETE FROM clients WHERE tenant_id=$1 AND external_id=$2 RETURNING id)
+		SELECT deleted.id, api_keys.key_hash FROM deleted
+		LEFT JOIN api_keys ON api_keys.client_id=deleted.id;`
+
+	rows, err := client.db.QueryContext(ctx, queryStr, tenant, externalID)
+	if err != nil {
+		client.log.Printf("unable to delete client: %s\n", err)
+		return false, nil, err
+	}
+	defer rows.Close()
+
+	deleted := false
+	var hashes []string
+	for rows.Next() {
+		var (
+			id   int
+			hash sql.NullString
+		)
+		if err := rows.Scan(&id, &hash); err != nil {
+			client.log.Printf("unable to scan result: %s\n", err)
+			return false, nil, err
+		}
+		deleted = true
+		if hash.Valid {
+			hashes = append(hashes, hash.String)
+		}
+	}
+	return deleted, hashes, rows.Err()
+}
+
+// clientConflict returns a conflict error if err violates a unique constraint of clients.
+func clientConflict(err error, c models.Client) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != uniqueViolation {
+		return nil
+	}
+	switch pqErr.Constraint {
+	case "clients_tenant_id_external_id_key":
+		return e.ConflictError{Message: fmt.Sprintf("client %s already exists", c.ExternalID)}
+	case "clients_tenant_id_phone_key":
+		return e.ConflictError{Message: fmt.Sprintf("client with phone %s already exists", c.Phone)}
+	}
+	return e.ConflictError{Message: "client already exists"}
+}
+
+func scanClient(row scanner) (*models.Client, error) {
+	var (
+		c     models.Client
+		email sql.NullString
+	)
+	if err := row.Scan(&c.ID, &c.ExternalID, &c.Username, &c.Phone, &email); err != nil {
+		return nil, err
+	}
+	c.Email = email.String
+	return &c, nil
+}
+
+func nullString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
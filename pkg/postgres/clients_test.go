@@ -0,0 +1,77 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"regexp"
+	"testing"
+	e "warehouse-system/errors"
+	"warehouse-system/pkg/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+func TestCreateClientConflict(t *testing.T) {
+	client := models.Client{ExternalID: "c-1", Username: "john", Phone: "+14155552671"}
+
+	tests := []struct {
+		name    string
+		err     error
+		message string
+	}{
+		{"external id", &pq.Error{Code: uniqueViolation, Constraint: "clients_tenant_id_external_id_key"},
+			"client c-1 already exists"},
+		{"phone", &pq.Error{Code: uniqueViolation, Constraint: "clients_tenant_id_phone_key"},
+			"client with phone +14155552671 already exists"},
+		{"other constraint", &pq.Error{Code: uniqueViolation, Constraint: "clients_other_key"},
+			"client already exists"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO clients")).
+				WithArgs("tenant-a", client.ExternalID, client.Username, client.Phone, nil).
+				WillReturnError(test.err)
+
+			pg := &Client{log: log.New(ioutil.Discard, "", 0), db: db}
+			_, err = pg.CreateClient(context.Background(), "tenant-a", client)
+
+			var conflictErr e.ConflictError
+			if !errors.As(err, &conflictErr) {
+				t.Fatalf("CreateClient = %v, want a conflict error", err)
+			}
+			if conflictErr.Message != test.message {
+				t.Fatalf("conflict message = %q, want %q", conflictErr.Message, test.message)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestCreateClientError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// other errors, e.g. a violated foreign key of an unknown tenant, are not conflicts
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO clients")).
+		WillReturnError(&pq.Error{Code: "23503", Constraint: "clients_tenant_id_fkey"})
+
+	pg := &Client{log: log.New(ioutil.Discard, "", 0), db: db}
+	_, err = pg.CreateClient(context.Background(), "unknown", models.Client{ExternalID: "c-1"})
+
+	var conflictErr e.ConflictError
+	if err == nil || errors.As(err, &conflictErr) {
+		t.Fatalf("CreateClient = %v, want a non-conflict error", err)
+	}
+}
@@ -0,0 +1,45 @@
+package postgres
+
+import (
+	"context"
+	"github.com/lib/pq"
+	"time"
+)
+
+// Listen passes payloads of notifications on the channel to onNotify until ctx is cancelled.
+// An empty payload is passed after the connection is re-established, since notifications
+// could have been missed meanwhile.
+func (client *Client) Listen(ctx context.Context, channel string, onNotify func(payload string)) error {
+	listener := pq.NewListener(client.connStr, time.Second, time.Minute,
+		func(event pq.ListenerEventType, err error) {
+			if err != nil {
+				client.log.Printf("Listener connection event %d: %s\n", event, err)
+			}
+		})
+	defer listener.Close()
+
+	if err := listener.Listen(channel); err != nil {
+		return err
+	}
+	client.log.Printf("Listening on channel %s.\n", channel)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case notification := <-listener.Notify:
+			if notification == nil {
+				onNotify("")
+				continue
+			}
+			onNotify(notification.Extra)
+		case <-time.After(90 * time.Second):
+			// check the connection is alive, a dead one is re-established by the listener
+			go func() {
+				if err := listener.Ping(); err != nil {
+					client.log.Printf("Listener ping failed: %s\n", err)
+				}
+			}()
+		}
+	}
+}
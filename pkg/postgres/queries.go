@@ -1,11 +1,13 @@
 package postgres
 
 import (
+	"context"
 	"log"
+	"time"
 	"warehouse-system/pkg/models"
 )
 
-func (client *Client) GetBoughtProductsQuantity() ([]models.BoughtProductsQuantity, error) {
+func (client *Client) GetBoughtProductsQuantity(ctx context.Context, tenant string) ([]models.BoughtProductsQuantity, error) {
 	log.SetPrefix("[Client.GetBoughtProductsQuantity]")
 
 	queryStr := `
@@ -14,10 +16,12 @@ func (client *Client) GetBoughtProductsQuantity() ([]models.BoughtProductsQuanti
 		manufacturers.name AS manufacturer, clients.username AS client
 		FROM orders JOIN products ON orders.product_id=products.id
 		JOIN clients ON orders.client_id=clients.id
-		JOIN manufacturers ON products.manufacturer_id=manufacturers.id)
+		JOIN manufacturers ON products.manufacturer_id=manufacturers.id
+		WHERE orders.tenant_id=$1 AND products.tenant_id=$1
+		AND clients.tenant_id=$1 AND manufacturers.tenant_id=$1)
 		AS orders_list GROUP BY manufacturer;`
 
-	rows, err := client.db.Query(queryStr)
+	rows, err := client.db.QueryContext(ctx, queryStr, tenant)
 	if err != nil {
 		client.log.Printf("unable to query: %s\n", err)
 		return nil, err
@@ -42,7 +46,7 @@ func (client *Client) GetBoughtProductsQuantity() ([]models.BoughtProductsQuanti
 	return quantities, nil
 }
 
-func (client *Client) GetBoughtItemsQuantity() ([]models.BoughtItemsQuantity, error) {
+func (client *Client) GetBoughtItemsQuantity(ctx context.Context, tenant string) ([]models.BoughtItemsQuantity, error) {
 	log.SetPrefix("[Client.GetBoughtItemsQuantity]")
 
 	queryStr := `
@@ -51,10 +55,12 @@ func (client *Client) GetBoughtItemsQuantity() ([]models.BoughtItemsQuantity, er
 		manufacturers.name AS manufacturer, clients.username AS client
 		FROM orders JOIN products ON orders.product_id=products.id
 		JOIN clients ON orders.client_id=clients.id
-		JOIN manufacturers ON products.manufacturer_id=manufacturers.id)
+		JOIN manufacturers ON products.manufacturer_id=manufacturers.id
+		WHERE orders.tenant_id=$1 AND products.tenant_id=$1
+		AND clients.tenant_id=$1 AND manufacturers.tenant_id=$1)
 		AS orders_list GROUP BY manufacturer;`
 
-	rows, err := client.db.Query(queryStr)
+	rows, err := client.db.QueryContext(ctx, queryStr, tenant)
 	if err != nil {
 		client.log.Printf("unable to query: %s\n", err)
 		return nil, err
@@ -79,12 +85,70 @@ func (client *Client) GetBoughtItemsQuantity() ([]models.BoughtItemsQuantity, er
 	return quantities, nil
 }
 
-func (client *Client) GetExpiredProductsQuantity() {
+func (client *Client) GetExpiredProductsQuantity(ctx context.Context, tenant string,
+	asOf time.Time) ([]models.ExpiredProductsQuantity, error) {
+	log.SetPrefix("[Client.GetExpiredProductsQuantity]")
 
+	queryStr := `
+        SELECT manufacturers.name AS manufacturer, COUNT(products.id) AS expired_products_quantity
+		FROM products JOIN manufacturers ON products.manufacturer_id=manufacturers.id
+		WHERE products.tenant_id=$1 AND manufacturers.tenant_id=$1 AND products.expires_at <= $2
+		GROUP BY manufacturers.name;`
+
+	rows, err := client.db.QueryContext(ctx, queryStr, tenant, asOf)
+	if err != nil {
+		client.log.Printf("unable to query: %s\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var quantities []models.ExpiredProductsQuantity
+	for rows.Next() {
+		var (
+			manufacturer string
+			quantity     int
+		)
+		if err := rows.Scan(&manufacturer, &quantity); err != nil {
+			log.Printf("unable to scan result: %s\n", err)
+			return nil, err
+		}
+		quantities = append(quantities, models.ExpiredProductsQuantity{
+			Manufacturer:            manufacturer,
+			ExpiredProductsQuantity: quantity,
+		})
+	}
+	return quantities, nil
 }
 
-func (client *Client) GetOrderedProductItemsQuantity() {
+func (client *Client) GetOrderedProductItemsQuantity(ctx context.Context, tenant string) ([]models.OrderedProductItemsQuantity, error) {
+	log.SetPrefix("[Client.GetOrderedProductItemsQuantity]")
+
+	queryStr := `
+        SELECT products.external_id, products.name AS product, manufacturers.name AS manufacturer,
+		SUM(orders.quantity) AS ordered_items_quantity, COUNT(DISTINCT orders.client_id) AS clients_quantity
+		FROM orders JOIN products ON orders.product_id=products.id
+		JOIN manufacturers ON products.manufacturer_id=manufacturers.id
+		WHERE orders.tenant_id=$1 AND products.tenant_id=$1 AND manufacturers.tenant_id=$1
+		GROUP BY products.id, products.external_id, products.name, manufacturers.name;`
 
+	rows, err := client.db.QueryContext(ctx, queryStr, tenant)
+	if err != nil {
+		client.log.Printf("unable to query: %s\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var quantities []models.OrderedProductItemsQuantity
+	for rows.Next() {
+		var item models.OrderedProductItemsQuantity
+		if err := rows.Scan(&item.ExternalID, &item.Product, &item.Manufacturer,
+			&item.OrderedItemsQuantity, &item.ClientsQuantity); err != nil {
+			log.Printf("unable to scan result: %s\n", err)
+			return nil, err
+		}
+		quantities = append(quantities, item)
+	}
+	return quantities, nil
 }
 
 // SELECT orders.id, orders.quantity, products.name AS product, manufacturers.name AS manufacturer,
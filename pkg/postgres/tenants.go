@@ -0,0 +1,24 @@
+package postgres
+
+import "context"
+
+// GetTenants returns ids of all tenants.
+func (client *Client) GetTenants(ctx context.Context) ([]string, error) {
+	rows, err := client.db.QueryContext(ctx, `SELECT id FROM tenants ORDER BY id;`)
+	if err != nil {
+		client.log.Printf("unable to query: %s\n", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tenants []string
+	for rows.Next() {
+		var tenant string
+		if err := rows.Scan(&tenant); err != nil {
+			client.log.Printf("unable to scan result: %s\n", err)
+			return nil, err
+		}
+		tenants = append(tenants, tenant)
+	}
+	return tenants, rows.Err()
+}
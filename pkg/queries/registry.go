@@ -0,0 +1,188 @@
+package queries
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"warehouse-system/pkg/postgres"
+)
+
+// Params are optional arguments of a report query. They are a part of the queue request
+// and of the cache key, so different arguments are computed and cached separately.
+type Params struct {
+	AsOf *time.Time `json:"as_of,omitempty"`
+}
+
+func (params Params) Encode() string {
+	values := url.Values{}
+	if params.AsOf != nil {
+		values.Set("as_of", strconv.FormatInt(params.AsOf.Unix(), 10))
+	}
+	return values.Encode()
+}
+
+func DecodeParams(raw string) (Params, error) {
+	var params Params
+	values, err := url.ParseQuery(raw)
+	if err != nil {
+		return params, err
+	}
+	if asOf := values.Get("as_of"); asOf != "" {
+		timestamp, err := strconv.ParseInt(asOf, 10, 64)
+		if err != nil {
+			return params, err
+		}
+		t := time.Unix(timestamp, 0).UTC()
+		params.AsOf = &t
+	}
+	return params, nil
+}
+
+// Query describes a report: how it is fetched from postgres, where and how long it is cached,
+// and how it is exposed over HTTP.
+type Query struct {
+	// Name identifies the query in the request queue, e.g. "products:bought".
+	Name string
+	// Path is the HTTP endpoint of the report.
+	Path string
+	// SoftTTL is the time the cached report is fresh. After it the stale report is still served
+	// while it is recomputed in background.
+	SoftTTL time.Duration
+	// TTL is the cache expiration time, after it callers wait for the report to be computed.
+	TTL time.Duration
+	// Row is a zero value of the result row type, results are slices of it.
+	Row interface{}
+	// RowKey returns the cache hash field of a result row.
+	RowKey func(row interface{}) string
+	// Fetch runs the report over the data of the tenant in postgres, the query is cancelled with the context.
+	Fetch func(ctx context.Context, pg *postgres.Client, tenant string, params Params) (interface{}, error)
+	// Tables lists tables the report is computed from, their changes invalidate the cache.
+	Tables []string
+	// ParseParams reads query arguments from the HTTP request, nil if the report has none.
+	ParseParams func(values url.Values) (Params, error)
+}
+
+// CacheKey returns the report name with encoded params if any. It is prefixed by the tenant,
+// so reports of tenants are cached, claimed and refreshed separately.
+func (query *Query) CacheKey(tenant string, params Params) string {
+	key := "tenant:" + tenant + ":" + query.Name
+	if encoded := params.Encode(); encoded != "" {
+		return key + "?" + encoded
+	}
+	return key
+}
+
+// ParamsCachePattern matches cache keys of the report with params of the tenant,
+// of all tenants if the tenant is empty.
+func (query *Query) ParamsCachePattern(tenant string) string {
+	if tenant == "" {
+		return "tenant:*:" + query.Name + `\?*`
+	}
+	return "tenant:" + globPattern.Replace(tenant) + ":" + query.Name + `\?*`
+}
+
+// globPattern escapes characters special to redis key patterns.
+var globPattern = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// ComputedAtField is a reserved cache hash field holding the unix time the report was computed at.
+// It is always written, so an empty report is cached as a hash with this field only.
+const ComputedAtField = "__computed_at"
+
+// Encode converts a result slice to cache hash fields: a row key followed by the row as JSON.
+func (query *Query) Encode(result interface{}, computedAt time.Time) ([]string, error) {
+	rows := reflect.ValueOf(result)
+	if rows.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("%s result must be a slice, got %T", query.Name, result)
+	}
+	fields := []string{ComputedAtField, strconv.FormatInt(computedAt.Unix(), 10)}
+	for i := 0; i < rows.Len(); i++ {
+		row := rows.Index(i).Interface()
+		value, err := json.Marshal(row)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, query.RowKey(row), string(value))
+	}
+	return fields, nil
+}
+
+func (query *Query) DependsOn(table string) bool {
+	for _, t := range query.Tables {
+		if t == table {
+			return true
+		}
+	}
+	return false
+}
+
+// IsStale reports whether the cached report is older than the soft TTL.
+func (query *Query) IsStale(fields map[string]string, now time.Time) bool {
+	computedAt, err := strconv.ParseInt(fields[ComputedAtField], 10, 64)
+	if err != nil {
+		return true
+	}
+	return now.Sub(time.Unix(computedAt, 0)) > query.SoftTTL
+}
+
+// Decode converts cache hash fields back to a result slice. It never returns a nil slice.
+func (query *Query) Decode(fields map[string]string) (interface{}, error) {
+	rowType := reflect.TypeOf(query.Row)
+	rows := reflect.MakeSlice(reflect.SliceOf(rowType), 0, len(fields))
+	for key, value := range fields {
+		if key == ComputedAtField {
+			continue
+		}
+		row := reflect.New(rowType)
+		if err := json.Unmarshal([]byte(value), row.Interface()); err != nil {
+			return nil, err
+		}
+		rows = reflect.Append(rows, row.Elem())
+	}
+	return rows.Interface(), nil
+}
+
+// DecodePayload converts a report published as JSON to a result slice. It never returns a nil slice.
+func (query *Query) DecodePayload(payload []byte) (interface{}, error) {
+	rows := reflect.New(reflect.SliceOf(reflect.TypeOf(query.Row)))
+	if err := json.Unmarshal(payload, rows.Interface()); err != nil {
+		return nil, err
+	}
+	if rows.Elem().IsNil() {
+		return reflect.MakeSlice(rows.Elem().Type(), 0, 0).Interface(), nil
+	}
+	return rows.Elem().Interface(), nil
+}
+
+type Registry struct {
+	queries map[string]*Query
+	ordered []*Query
+}
+
+func (registry *Registry) Register(query *Query) {
+	if _, ok := registry.queries[query.Name]; ok {
+		panic("query is already registered: " + query.Name)
+	}
+	registry.queries[query.Name] = query
+	registry.ordered = append(registry.ordered, query)
+}
+
+func (registry *Registry) Get(name string) (*Query, bool) {
+	query, ok := registry.queries[name]
+	return query, ok
+}
+
+// Queries returns registered queries in registration order.
+func (registry *Registry) Queries() []*Query {
+	return registry.ordered
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		queries: make(map[string]*Query),
+	}
+}
@@ -0,0 +1,101 @@
+package queries
+
+import (
+	"context"
+	"net/url"
+	"time"
+	"warehouse-system/config"
+	e "warehouse-system/errors"
+	"warehouse-system/pkg/models"
+	"warehouse-system/pkg/postgres"
+)
+
+// NewReportsRegistry registers all reports served by the web server and computed by the query worker.
+func NewReportsRegistry(config *config.AppConfig) *Registry {
+	softTTL := time.Duration(config.CacheExpireDuration) * time.Second
+	ttl := time.Duration(config.CacheHardExpireDuration) * time.Second
+	if ttl < softTTL {
+		ttl = softTTL
+	}
+
+	registry := NewRegistry()
+	registry.Register(&Query{
+		Name:    "products:bought",
+		Path:    "/products/bought",
+		SoftTTL: softTTL,
+		TTL:     ttl,
+		Row:     models.BoughtProductsQuantity{},
+		Tables:  []string{"orders", "products", "manufacturers"},
+		RowKey: func(row interface{}) string {
+			return row.(models.BoughtProductsQuantity).Manufacturer
+		},
+		Fetch: func(ctx context.Context, pg *postgres.Client, tenant string, params Params) (interface{}, error) {
+			return pg.GetBoughtProductsQuantity(ctx, tenant)
+		},
+	})
+	registry.Register(&Query{
+		Name:    "items:bought",
+		Path:    "/products/items/bought",
+		SoftTTL: softTTL,
+		TTL:     ttl,
+		Row:     models.BoughtItemsQuantity{},
+		Tables:  []string{"orders", "products", "manufacturers"},
+		RowKey: func(row interface{}) string {
+			return row.(models.BoughtItemsQuantity).Manufacturer
+		},
+		Fetch: func(ctx context.Context, pg *postgres.Client, tenant string, params Params) (interface{}, error) {
+			return pg.GetBoughtItemsQuantity(ctx, tenant)
+		},
+	})
+	registry.Register(&Query{
+		Name:    "items:ordered",
+		Path:    "/products/items/ordered",
+		SoftTTL: softTTL,
+		TTL:     ttl,
+		Row:     models.OrderedProductItemsQuantity{},
+		Tables:  []string{"orders", "products", "manufacturers"},
+		RowKey: func(row interface{}) string {
+			return row.(models.OrderedProductItemsQuantity).ExternalID
+		},
+		Fetch: func(ctx context.Context, pg *postgres.Client, tenant string, params Params) (interface{}, error) {
+			return pg.GetOrderedProductItemsQuantity(ctx, tenant)
+		},
+	})
+	registry.Register(&Query{
+		Name:    "products:expired",
+		Path:    "/products/expired",
+		SoftTTL: softTTL,
+		TTL:     ttl,
+		Row:     models.ExpiredProductsQuantity{},
+		Tables:  []string{"products", "manufacturers"},
+		RowKey: func(row interface{}) string {
+			return row.(models.ExpiredProductsQuantity).Manufacturer
+		},
+		Fetch: func(ctx context.Context, pg *postgres.Client, tenant string, params Params) (interface{}, error) {
+			// expires_at has no time zone, the time is compared in UTC
+			asOf := time.Now().UTC()
+			if params.AsOf != nil {
+				asOf = params.AsOf.UTC()
+			}
+			return pg.GetExpiredProductsQuantity(ctx, tenant, asOf)
+		},
+		ParseParams: parseAsOf,
+	})
+	return registry
+}
+
+// parseAsOf reads an optional RFC3339 "as_of" argument. The cache key holds it in whole seconds,
+// so fractions are rejected, and it is converted to UTC, so the same instant written with
+// different offsets computes the same report.
+func parseAsOf(values url.Values) (Params, error) {
+	var params Params
+	if asOf := values.Get("as_of"); asOf != "" {
+		t, err := time.Parse(time.RFC3339, asOf)
+		if err != nil || t.Nanosecond() != 0 {
+			return params, e.BadRequestError{Message: "as_of must be an RFC3339 timestamp in whole seconds"}
+		}
+		t = t.UTC()
+		params.AsOf = &t
+	}
+	return params, nil
+}
@@ -0,0 +1,55 @@
+package queries
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseAsOf(t *testing.T) {
+	want := time.Date(2026, 10, 17, 12, 0, 29, 0, time.UTC)
+
+	tests := []struct {
+		asOf  string
+		valid bool
+	}{
+		{"2026-10-17T12:00:29Z", true},
+		{"2026-10-17T14:00:29+02:00", true},
+		{"2026-10-17T07:00:29-05:00", true},
+		{"2026-10-17T12:00:29.5Z", false},
+		{"2026-10-17 12:00:29", false},
+		{"1792238429", false},
+	}
+	for _, test := range tests {
+		params, err := parseAsOf(url.Values{"as_of": {test.asOf}})
+		if !test.valid {
+			if err == nil {
+				t.Errorf("parseAsOf(%s) = %v, want an error", test.asOf, params.AsOf)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseAsOf(%s) = %s", test.asOf, err)
+			continue
+		}
+		// the instant is kept as requested and bound to the timestamp column in UTC
+		if !params.AsOf.Equal(want) || params.AsOf.Location() != time.UTC {
+			t.Errorf("parseAsOf(%s) = %s, want %s", test.asOf, params.AsOf, want)
+		}
+	}
+
+	params, err := parseAsOf(url.Values{})
+	if err != nil || params.AsOf != nil {
+		t.Errorf("parseAsOf without as_of = %v, %v, want no params", params.AsOf, err)
+	}
+}
+
+func TestDecodeParamsInUTC(t *testing.T) {
+	params, err := DecodeParams("as_of=1792238429")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.AsOf == nil || params.AsOf.Location() != time.UTC || params.AsOf.Unix() != 1792238429 {
+		t.Fatalf("DecodeParams = %v, want 1792238429 in UTC", params.AsOf)
+	}
+}
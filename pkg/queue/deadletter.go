@@ -0,0 +1,51 @@
+package queue
+
+import (
+	"fmt"
+	"time"
+)
+
+// DeadLetter is a request that failed or couldn't be decoded, kept for inspection and replay.
+type DeadLetter struct {
+	ID string `json:"id"`
+	// MessageID is empty for messages that couldn't be decoded
+	MessageID string    `json:"message_id,omitempty"`
+	Message   string    `json:"message"`
+	Reason    string    `json:"reason"`
+	Attempts  []Attempt `json:"attempts"`
+	// EnqueuedAt is zero for messages that couldn't be decoded
+	EnqueuedAt time.Time `json:"enqueued_at"`
+	FailedAt   time.Time `json:"failed_at"`
+}
+
+// NewDeadLetter records a failure of the request. Every failure gets its own record, so failures
+// of a replayed message don't overwrite each other.
+func NewDeadLetter(raw string, message *Message, reason string) *DeadLetter {
+	deadLetter := &DeadLetter{
+		ID:       NewMessageID(),
+		Message:  raw,
+		Reason:   reason,
+		FailedAt: time.Now(),
+	}
+	if message != nil {
+		deadLetter.MessageID = message.ID
+		deadLetter.Attempts = message.History
+		deadLetter.EnqueuedAt = message.EnqueuedAt
+	}
+	return deadLetter
+}
+
+// Replay returns the dead-lettered message ready to be put to the queue again:
+// nobody waits for the original reply, so there is no deadline, and attempts start over.
+func (deadLetter *DeadLetter) Replay() (string, error) {
+	message, err := Decode(deadLetter.Message)
+	if err != nil {
+		return "", fmt.Errorf("dead letter %s can't be replayed: %s", deadLetter.ID, err)
+	}
+	message.Version = MessageVersion
+	message.EnqueuedAt = time.Now()
+	message.Attempt = 0
+	message.Deadline = nil
+	message.History = nil
+	return message.Encode()
+}
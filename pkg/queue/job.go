@@ -0,0 +1,232 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"warehouse-system/pkg/queries"
+)
+
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobThrottled = "throttled"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// jobTopicPrefix marks reply topics of async jobs, results published to them update the job state.
+const jobTopicPrefix = "job:"
+
+// JobCompleted is the event streamed when the job has succeeded or failed.
+const JobCompleted = "completed"
+
+// JobPosition is the event streamed when the position of a queued job changes.
+const JobPosition = "position"
+
+// Job is a report request processed asynchronously, the client polls its state instead of
+// waiting for the result.
+type Job struct {
+	ID     string         `json:"id"`
+	Token  string         `json:"-"`
+	Tenant string         `json:"-"`
+	Query  string         `json:"query"`
+	Params queries.Params `json:"params"`
+	Status string         `json:"status"`
+	// RetryAt is the time the throttled job is put back to the queue
+	RetryAt *time.Time `json:"retry_at,omitempty"`
+	// Result is the computed report as JSON, it is set when the job has succeeded
+	Result json.RawMessage `json:"result,omitempty"`
+	// Error is the failure status of the query worker
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Request is the queued request of the job, it is used to find the job position in the queue
+	Request string `json:"-"`
+}
+
+// JobEvent is a job lifecycle event streamed to the client.
+type JobEvent struct {
+	// Event is the job status, position or completed
+	Event  string `json:"event"`
+	Status string `json:"status"`
+	// Position is the number of requests ahead of the queued job
+	Position *int64          `json:"position,omitempty"`
+	RetryAt  *time.Time      `json:"retry_at,omitempty"`
+	Result   json.RawMessage `json:"result,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	At       time.Time       `json:"at"`
+}
+
+// Completed reports whether no events follow this one.
+func (event JobEvent) Completed() bool {
+	return event.Event == JobCompleted
+}
+
+func (event JobEvent) Encode() (string, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func DecodeJobEvent(raw string) (JobEvent, error) {
+	var event JobEvent
+	err := json.Unmarshal([]byte(raw), &event)
+	return event, err
+}
+
+// Event returns the event of the current job state, it is streamed first to a new subscriber.
+func (job *Job) Event() JobEvent {
+	event := JobEvent{
+		Event:   job.Status,
+		Status:  job.Status,
+		RetryAt: job.RetryAt,
+		Result:  job.Result,
+		Error:   job.Error,
+		At:      job.UpdatedAt,
+	}
+	if job.Status == JobSucceeded || job.Status == JobFailed {
+		event.Event = JobCompleted
+	}
+	return event
+}
+
+// Fields returns the job as redis hash fields.
+func (job *Job) Fields() (map[string]interface{}, error) {
+	params, err := json.Marshal(job.Params)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{
+		"token":      job.Token,
+		"tenant":     job.Tenant,
+		"query":      job.Query,
+		"params":     string(params),
+		"status":     job.Status,
+		"created_at": job.CreatedAt.Format(time.RFC3339Nano),
+		"updated_at": job.UpdatedAt.Format(time.RFC3339Nano),
+	}
+	if len(job.Result) != 0 {
+		fields["result"] = string(job.Result)
+	}
+	if job.Error != "" {
+		fields["error"] = job.Error
+	}
+	if job.Request != "" {
+		fields["request"] = job.Request
+	}
+	return fields, nil
+}
+
+// DecodeJob parses the job from redis hash fields.
+func DecodeJob(id string, fields map[string]string) (*Job, error) {
+	job := &Job{
+		ID:      id,
+		Token:   fields["token"],
+		Tenant:  fields["tenant"],
+		Query:   fields["query"],
+		Status:  fields["status"],
+		Error:   fields["error"],
+		Request: fields["request"],
+	}
+	if err := json.Unmarshal([]byte(fields["params"]), &job.Params); err != nil {
+		return nil, fmt.Errorf("invalid job params: %s", err)
+	}
+	if result := fields["result"]; result != "" {
+		job.Result = json.RawMessage(result)
+	}
+	if retryAt := fields["retry_at"]; retryAt != "" {
+		at, err := time.Parse(time.RFC3339Nano, retryAt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid job retry time: %s", err)
+		}
+		job.RetryAt = &at
+	}
+	var err error
+	if job.CreatedAt, err = time.Parse(time.RFC3339Nano, fields["created_at"]); err != nil {
+		return nil, fmt.Errorf("invalid job creation time: %s", err)
+	}
+	if job.UpdatedAt, err = time.Parse(time.RFC3339Nano, fields["updated_at"]); err != nil {
+		return nil, fmt.Errorf("invalid job update time: %s", err)
+	}
+	return job, nil
+}
+
+// JobStatusFields returns the hash fields changed when the job moves to the status.
+func JobStatusFields(status string, now time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"status":     status,
+		"retry_at":   "",
+		"updated_at": now.Format(time.RFC3339Nano),
+	}
+}
+
+// JobThrottledFields returns the hash fields changed when the job request is delayed till retryAt.
+func JobThrottledFields(request string, retryAt, now time.Time) map[string]interface{} {
+	fields := JobStatusFields(JobThrottled, now)
+	fields["retry_at"] = retryAt.Format(time.RFC3339Nano)
+	fields["request"] = request
+	return fields
+}
+
+// JobResultFields returns the hash fields changed when the query worker publishes the job result.
+func JobResultFields(result Result, now time.Time) map[string]interface{} {
+	if result.Status != "success" {
+		fields := JobStatusFields(JobFailed, now)
+		fields["error"] = result.Status
+		return fields
+	}
+	fields := JobStatusFields(JobSucceeded, now)
+	fields["result"] = string(result.Payload)
+	return fields
+}
+
+// NewJobResultEvent returns the completed event of the result published by the query worker.
+func NewJobResultEvent(result Result, now time.Time) JobEvent {
+	if result.Status != "success" {
+		return JobEvent{Event: JobCompleted, Status: JobFailed, Error: result.Status, At: now}
+	}
+	return JobEvent{Event: JobCompleted, Status: JobSucceeded, Result: result.Payload, At: now}
+}
+
+// JobTopic returns the reply topic of the job.
+func JobTopic(id string) string {
+	return jobTopicPrefix + id
+}
+
+// JobEventsTopic returns the topic job events are published to.
+func JobEventsTopic(id string) string {
+	return jobTopicPrefix + id + ":events"
+}
+
+// JobID returns the job id if the topic is a reply topic of a job.
+func JobID(topic string) (string, bool) {
+	if !strings.HasPrefix(topic, jobTopicPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(topic, jobTopicPrefix), true
+}
+
+func NewJob(token, tenant, query string, params queries.Params) *Job {
+	now := time.Now()
+	return &Job{
+		ID:        NewMessageID(),
+		Token:     token,
+		Tenant:    tenant,
+		Query:     query,
+		Params:    params,
+		Status:    JobQueued,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// NewJobMessage returns the queue message of the job, its result is published to the job topic.
+func NewJobMessage(job *Job, deadline time.Time, trace TraceContext) *Message {
+	message := NewMessage(job.Token, job.Tenant, job.ID, job.Query, job.Params, deadline, trace)
+	message.ReplyTo = JobTopic(job.ID)
+	return message
+}
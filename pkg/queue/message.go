@@ -0,0 +1,159 @@
+package queue
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"warehouse-system/pkg/models"
+	"warehouse-system/pkg/queries"
+)
+
+// MessageVersion is the version of the message envelope written to the queue.
+const MessageVersion = 1
+
+// TraceContext carries W3C trace context headers through the queue.
+type TraceContext struct {
+	TraceParent string `json:"traceparent,omitempty"`
+	TraceState  string `json:"tracestate,omitempty"`
+}
+
+// Message is a request envelope put to the queue by the web server and consumed by query workers.
+type Message struct {
+	Version    int            `json:"v"`
+	ID         string         `json:"id"`
+	Token      string         `json:"token"`
+	Tenant     string         `json:"tenant"`
+	ReplyTo    string         `json:"reply_to"`
+	Query      string         `json:"query"`
+	Params     queries.Params `json:"params"`
+	EnqueuedAt time.Time      `json:"enqueued_at"`
+	Attempt    int            `json:"attempt"`
+	Deadline   *time.Time     `json:"deadline,omitempty"`
+	Trace      TraceContext   `json:"trace"`
+	History    []Attempt      `json:"history,omitempty"`
+	// Force recomputes the report even if its cache is fresh
+	Force bool `json:"force,omitempty"`
+}
+
+// Attempt is a record of one processing attempt of a message.
+type Attempt struct {
+	At      time.Time `json:"at"`
+	Worker  string    `json:"worker"`
+	Outcome string    `json:"outcome"`
+}
+
+// Expired reports whether nobody waits for the result anymore.
+func (message *Message) Expired(now time.Time) bool {
+	return message.Deadline != nil && now.After(*message.Deadline)
+}
+
+func (message *Message) AddAttempt(worker, outcome string) {
+	message.History = append(message.History, Attempt{
+		At:      time.Now(),
+		Worker:  worker,
+		Outcome: outcome,
+	})
+}
+
+func (message *Message) Encode() (string, error) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Decode parses a queue message envelope. Messages without a tenant are rejected,
+// their reports can't be told apart from reports of other tenants.
+func Decode(raw string) (*Message, error) {
+	if IsLegacy(raw) {
+		return nil, fmt.Errorf("invalid message: legacy messages are not accepted")
+	}
+
+	var message Message
+	if err := json.Unmarshal([]byte(raw), &message); err != nil {
+		return nil, fmt.Errorf("invalid message: %s", err)
+	}
+	if message.Version != MessageVersion {
+		return nil, fmt.Errorf("unsupported message version %d", message.Version)
+	}
+	if message.Token == "" || message.Tenant == "" || message.ReplyTo == "" || message.Query == "" {
+		return nil, fmt.Errorf("message must have token, tenant, reply topic and query")
+	}
+	return &message, nil
+}
+
+// IsLegacy reports whether the message is in the "token:uid:query" format written by web servers
+// released before the JSON envelope.
+func IsLegacy(raw string) bool {
+	return !strings.HasPrefix(raw, "{")
+}
+
+// DecodeLegacy parses "token:uid:query", where the query may contain colons and encoded params.
+// Web servers writing it had no tenants, so the message belongs to the default tenant.
+// Query workers decode it only until the time set by LEGACY_MESSAGES_UNTIL.
+func DecodeLegacy(raw string) (*Message, error) {
+	parts := strings.SplitN(raw, ":", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return nil, fmt.Errorf("invalid legacy message")
+	}
+	token, uid, query := parts[0], parts[1], parts[2]
+
+	var params queries.Params
+	if i := strings.Index(query, "?"); i >= 0 {
+		var err error
+		if params, err = queries.DecodeParams(query[i+1:]); err != nil {
+			return nil, fmt.Errorf("invalid legacy message params: %s", err)
+		}
+		query = query[:i]
+	}
+
+	return &Message{
+		Version: MessageVersion,
+		ID:      uid,
+		Token:   token,
+		Tenant:  models.DefaultTenant,
+		ReplyTo: fmt.Sprintf("%s:%s", token, uid),
+		Query:   query,
+		Params:  params,
+	}, nil
+}
+
+func NewMessage(token, tenant, id, query string, params queries.Params, deadline time.Time, trace TraceContext) *Message {
+	return &Message{
+		Version:    MessageVersion,
+		ID:         id,
+		Token:      token,
+		Tenant:     tenant,
+		ReplyTo:    fmt.Sprintf("%s:%s", token, id),
+		Query:      query,
+		Params:     params,
+		EnqueuedAt: time.Now(),
+		Deadline:   &deadline,
+		Trace:      trace,
+	}
+}
+
+// NewTraceContext continues the trace of an incoming traceparent header or starts a new one.
+func NewTraceContext(traceParent, traceState string) TraceContext {
+	if traceParent == "" {
+		traceParent = fmt.Sprintf("00-%s-%s-01", randomHex(16), randomHex(8))
+	}
+	return TraceContext{
+		TraceParent: traceParent,
+		TraceState:  traceState,
+	}
+}
+
+func NewMessageID() string {
+	return randomHex(8)
+}
+
+func randomHex(size int) string {
+	b := make([]byte, size)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
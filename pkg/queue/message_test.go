@@ -0,0 +1,89 @@
+package queue
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"warehouse-system/pkg/queries"
+)
+
+func TestDecode(t *testing.T) {
+	valid := NewMessage("token", "tenant-a", "id", "products:bought", queries.Params{}, time.Now(), TraceContext{})
+
+	tests := []struct {
+		name  string
+		edit  func(message *Message)
+		valid bool
+	}{
+		{"valid", func(message *Message) {}, true},
+		{"no tenant", func(message *Message) { message.Tenant = "" }, false},
+		{"no token", func(message *Message) { message.Token = "" }, false},
+		{"no reply topic", func(message *Message) { message.ReplyTo = "" }, false},
+		{"no query", func(message *Message) { message.Query = "" }, false},
+		{"unknown version", func(message *Message) { message.Version = MessageVersion + 1 }, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			message := *valid
+			test.edit(&message)
+			raw, err := message.Encode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := Decode(raw)
+			if test.valid && err != nil {
+				t.Fatalf("Decode(%s) = %s, want a message", raw, err)
+			}
+			if !test.valid && err == nil {
+				t.Fatalf("Decode(%s) = %+v, want an error", raw, decoded)
+			}
+		})
+	}
+}
+
+func TestDecodeRejectsLegacyMessages(t *testing.T) {
+	raw := "token:id:products:bought"
+	if !IsLegacy(raw) {
+		t.Fatalf("IsLegacy(%q) = false", raw)
+	}
+	if message, err := Decode(raw); err == nil {
+		t.Fatalf("Decode(%q) = %+v, want an error", raw, message)
+	}
+}
+
+func TestDecodeLegacy(t *testing.T) {
+	tests := []struct {
+		raw   string
+		query string
+		asOf  int64
+		valid bool
+	}{
+		{"token:id:products:bought", "products:bought", 0, true},
+		{"token:id:products:expired?as_of=1700000000", "products:expired", 1700000000, true},
+		{"token:id", "", 0, false},
+		{"token::products:bought", "", 0, false},
+		{":id:products:bought", "", 0, false},
+		{"token:id:products:expired?as_of=now", "", 0, false},
+		{"", "", 0, false},
+	}
+	for _, test := range tests {
+		message, err := DecodeLegacy(test.raw)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("DecodeLegacy(%q) = %+v, want an error", test.raw, message)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("DecodeLegacy(%q) = %s", test.raw, err)
+			continue
+		}
+		if message.Query != test.query || message.Token != "token" || message.ID != "id" ||
+			message.Tenant == "" || !strings.HasPrefix(message.ReplyTo, "token:") {
+			t.Errorf("DecodeLegacy(%q) = %+v", test.raw, message)
+		}
+		if test.asOf != 0 && (message.Params.AsOf == nil || message.Params.AsOf.Unix() != test.asOf) {
+			t.Errorf("DecodeLegacy(%q) params = %+v, want as_of %d", test.raw, message.Params, test.asOf)
+		}
+	}
+}
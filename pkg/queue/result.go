@@ -0,0 +1,33 @@
+package queue
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Result is published by a query worker to the reply topic of a request.
+type Result struct {
+	// Status is success, internal_err, max_retry_count or unknown_query
+	Status string `json:"status"`
+	// Payload is the computed report as JSON, it is set on success
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+func (result Result) Encode() (string, error) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// DecodeResult parses a published result. Workers of the previous release publish
+// a bare status, it is decoded as a result without payload.
+func DecodeResult(raw string) (Result, error) {
+	if !strings.HasPrefix(raw, "{") {
+		return Result{Status: raw}, nil
+	}
+	var result Result
+	err := json.Unmarshal([]byte(raw), &result)
+	return result, err
+}
@@ -0,0 +1,54 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+// Leases limits the number of requests of a key processed at the same time. A lease
+// expires after its timeout, so a crashed holder doesn't keep the slot forever.
+type Leases struct {
+	ctx context.Context
+	rds redis.Cmdable
+}
+
+// acquireLeaseScript drops expired leases and adds the lease if there is a free slot.
+var acquireLeaseScript = redis.NewScript(`
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local timeout = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+if redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], now + timeout, ARGV[2])
+redis.call('PEXPIRE', KEYS[1], timeout)
+return 1`)
+
+// Acquire takes one of maxCount slots of the key for the lease until it is released or times out.
+func (leases *Leases) Acquire(key, lease string, maxCount int, timeout time.Duration) (bool, error) {
+	acquired, err := acquireLeaseScript.Run(leases.ctx, leases.rds, []string{leases.key(key)},
+		maxCount, lease, timeout.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return acquired == 1, nil
+}
+
+func (leases *Leases) Release(key, lease string) error {
+	return leases.rds.ZRem(leases.ctx, leases.key(key), lease).Err()
+}
+
+func (leases *Leases) key(key string) string {
+	return fmt.Sprintf("leases:%s", key)
+}
+
+func NewLeases(ctx context.Context, rds redis.Cmdable) *Leases {
+	return &Leases{
+		ctx: ctx,
+		rds: rds,
+	}
+}
@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultPlan is the name of the plan of tokens without an assigned plan.
+const DefaultPlan = "default"
+
+// Plan is a quota plan assigned to tokens. Zero rates and quotas aren't enforced.
+type Plan struct {
+	Name string `json:"name"`
+	// Concurrency is the number of queries of a token run by query workers at the same time
+	Concurrency int `json:"concurrency"`
+	// RateRequests is the number of requests to the web server per rate period
+	RateRequests int `json:"rate_requests"`
+	// QueryRateRequests is the number of queries run by query workers per rate period
+	QueryRateRequests int `json:"query_rate_requests"`
+	// RatePeriod is in seconds
+	RatePeriod int `json:"rate_period"`
+	Burst      int `json:"burst"`
+	// DailyQuota is the number of requests to the web server a day
+	DailyQuota    int `json:"daily_quota"`
+	MaxRetryCount int `json:"max_retry_count"`
+}
+
+// RequestLimit is the limit of requests to the web server.
+func (plan Plan) RequestLimit() Limit {
+	return Limit{
+		Requests: plan.RateRequests,
+		Period:   time.Duration(plan.RatePeriod) * time.Second,
+		Burst:    plan.Burst,
+	}
+}
+
+// QueryLimit is the limit of queries run by query workers.
+func (plan Plan) QueryLimit() Limit {
+	return Limit{
+		Requests: plan.QueryRateRequests,
+		Period:   time.Duration(plan.RatePeriod) * time.Second,
+		Burst:    plan.Burst,
+	}
+}
+
+func (plan Plan) Validate() error {
+	if plan.Name == "" {
+		return fmt.Errorf("plan name must be provided")
+	}
+	if plan.Concurrency < 1 {
+		return fmt.Errorf("concurrency must be positive")
+	}
+	if plan.RateRequests < 0 || plan.QueryRateRequests < 0 || plan.Burst < 0 ||
+		plan.DailyQuota < 0 || plan.MaxRetryCount < 0 {
+		return fmt.Errorf("rates, burst, daily quota and max retry count must not be negative")
+	}
+	if (plan.RateRequests > 0 || plan.QueryRateRequests > 0) && plan.RatePeriod < 1 {
+		return fmt.Errorf("rate period must be positive")
+	}
+	return nil
+}
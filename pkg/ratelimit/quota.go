@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+// Quotas count requests of a key a day. A counter expires at the end of its UTC day.
+type Quotas struct {
+	ctx context.Context
+	rds redis.Scripter
+}
+
+var takeQuotaScript = redis.NewScript(`
+local count = redis.call('INCR', KEYS[1])
+if count == 1 then
+	redis.call('PEXPIREAT', KEYS[1], ARGV[2])
+end
+local quota = tonumber(ARGV[1])
+if count > quota then
+	redis.call('DECR', KEYS[1])
+	return {0, 0}
+end
+return {1, quota - count}`)
+
+// Take counts the request of the key and tells whether it is within the daily quota.
+// A zero quota isn't enforced.
+func (quotas *Quotas) Take(key string, quota int) (Decision, error) {
+	if quota <= 0 {
+		return Decision{Allowed: true}, nil
+	}
+
+	now := time.Now().UTC()
+	year, month, day := now.Date()
+	end := time.Date(year, month, day+1, 0, 0, 0, 0, time.UTC)
+	result, err := takeQuotaScript.Run(quotas.ctx, quotas.rds, []string{quotas.key(key, now)},
+		quota, end.UnixMilli()).Int64Slice()
+	if err != nil {
+		return Decision{}, err
+	}
+
+	decision := Decision{
+		Allowed:    result[0] == 1,
+		Limit:      quota,
+		Remaining:  int(result[1]),
+		ResetAfter: end.Sub(now),
+	}
+	if !decision.Allowed {
+		decision.RetryAfter = decision.ResetAfter
+	}
+	return decision, nil
+}
+
+func (quotas *Quotas) key(key string, day time.Time) string {
+	return fmt.Sprintf("quota:%s:%s", key, day.Format("2006-01-02"))
+}
+
+func NewQuotas(ctx context.Context, rds redis.Scripter) *Quotas {
+	return &Quotas{
+		ctx: ctx,
+		rds: rds,
+	}
+}
@@ -0,0 +1,204 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	TokenBucket   = "token_bucket"
+	SlidingWindow = "sliding_window"
+)
+
+// Prefixes of the limiters of requests to the web server, of queries run by query workers
+// and of failed authentications of client addresses.
+const (
+	Requests     = "requests"
+	Queries      = "queries"
+	AuthFailures = "auth_failures"
+)
+
+// Limit allows Requests per Period. The token bucket also allows bursts of up to Burst
+// requests, the sliding window ignores it. A limit without requests isn't enforced.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+func (limit Limit) Enabled() bool {
+	return limit.Requests > 0 && limit.Period > 0
+}
+
+// capacity is the number of requests allowed at once.
+func (limit Limit) capacity() int {
+	if limit.Burst > 0 {
+		return limit.Burst
+	}
+	return limit.Requests
+}
+
+// Decision is the limiter state after a request has been counted.
+type Decision struct {
+	Allowed bool
+	// Limit is the number of requests allowed at once
+	Limit     int
+	Remaining int
+	// RetryAfter is the time until the next request is allowed, it is zero if the request is allowed
+	RetryAfter time.Duration
+	// ResetAfter is the time until the limit is fully available again
+	ResetAfter time.Duration
+}
+
+// Limiter counts requests in redis, so the limits are shared by all web servers and workers.
+// Keys of the limiter expire once the limit is fully available again.
+type Limiter struct {
+	ctx       context.Context
+	rds       redis.Scripter
+	algorithm string
+	// prefix separates the counters of different limiters of the same key
+	prefix string
+}
+
+// tokenBucketScript refills the bucket for the time since the last request and takes the cost
+// of the request, a zero cost only reads the bucket state. The time is read from redis, so the clocks of web servers and workers don't matter.
+var tokenBucketScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+	tokens = capacity
+	ts = now
+end
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - cost
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) / rate)
+end
+local reset = math.ceil((capacity - tokens) / rate)
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], reset + 1000)
+return {allowed, math.floor(tokens), retry, reset}`)
+
+// slidingWindowScript keeps the times of the requests within the window in a sorted set.
+// A request with a zero cost isn't added, it only reads the window state.
+var slidingWindowScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local cost = tonumber(ARGV[4])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+local allowed = 0
+if count < limit then
+	if cost > 0 then
+		redis.call('ZADD', KEYS[1], now, ARGV[3])
+		count = count + 1
+	end
+	allowed = 1
+end
+
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+local reset = 0
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+local retry = 0
+if allowed == 0 then
+	retry = reset
+end
+
+redis.call('PEXPIRE', KEYS[1], window)
+return {allowed, limit - count, retry, reset}`)
+
+// Allow counts the request of the key and tells whether it is within the limit.
+func (limiter *Limiter) Allow(key string, limit Limit) (Decision, error) {
+	return limiter.run(key, limit, 1)
+}
+
+// Peek returns the limiter state of the key without counting a request. Allowed tells
+// whether the next request would be allowed.
+func (limiter *Limiter) Peek(key string, limit Limit) (Decision, error) {
+	return limiter.run(key, limit, 0)
+}
+
+func (limiter *Limiter) run(key string, limit Limit, cost int) (Decision, error) {
+	if !limit.Enabled() {
+		return Decision{Allowed: true}, nil
+	}
+
+	var result []int64
+	var err error
+	period := limit.Period.Milliseconds()
+	switch limiter.algorithm {
+	case TokenBucket:
+		rate := float64(limit.Requests) / float64(period)
+		result, err = tokenBucketScript.Run(limiter.ctx, limiter.rds, []string{limiter.key(key)},
+			limit.capacity(), strconv.FormatFloat(rate, 'g', -1, 64), cost).Int64Slice()
+	case SlidingWindow:
+		result, err = slidingWindowScript.Run(limiter.ctx, limiter.rds, []string{limiter.key(key)},
+			limit.Requests, period, requestID(), cost).Int64Slice()
+	}
+	if err != nil {
+		return Decision{}, err
+	}
+
+	decision := Decision{
+		Allowed:    result[0] == 1,
+		Limit:      limit.Requests,
+		Remaining:  int(result[1]),
+		RetryAfter: time.Duration(result[2]) * time.Millisecond,
+		ResetAfter: time.Duration(result[3]) * time.Millisecond,
+	}
+	if limiter.algorithm == TokenBucket {
+		decision.Limit = limit.capacity()
+	}
+	return decision, nil
+}
+
+func (limiter *Limiter) key(key string) string {
+	return fmt.Sprintf("ratelimit:%s:%s", limiter.prefix, key)
+}
+
+var (
+	requestMu  sync.Mutex
+	requestRnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// requestID makes sorted set members of requests counted in the same millisecond unique.
+func requestID() string {
+	requestMu.Lock()
+	defer requestMu.Unlock()
+	return strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.FormatInt(requestRnd.Int63(), 36)
+}
+
+func NewLimiter(ctx context.Context, rds redis.Scripter, algorithm, prefix string) (*Limiter, error) {
+	if algorithm != TokenBucket && algorithm != SlidingWindow {
+		return nil, fmt.Errorf("unknown rate limit algorithm %s", algorithm)
+	}
+	return &Limiter{
+		ctx:       ctx,
+		rds:       rds,
+		algorithm: algorithm,
+		prefix:    prefix,
+	}, nil
+}
@@ -2,14 +2,16 @@ package redis
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/go-redis/redis/v8"
 	"log"
-	"strconv"
 	"time"
 	"warehouse-system/config"
 	e "warehouse-system/errors"
 	"warehouse-system/pkg/models"
+	"warehouse-system/pkg/queue"
+	"warehouse-system/pkg/ratelimit"
 )
 
 type Client struct {
@@ -24,80 +26,101 @@ func (client *Client) Close() {
 	}
 }
 
-func (client *Client) GetBoughtProductsCache() ([]models.BoughtProductsQuantity, error) {
-	result, err := client.rds.HGetAll(client.ctx, "products:bought").Result()
+// GetCache returns the cache hash fields, it returns an empty map if the key doesn't exist.
+func (client *Client) GetCache(key string) (map[string]string, error) {
+	result, err := client.rds.HGetAll(client.ctx, key).Result()
 	if err == redis.Nil {
 		return nil, nil
-	} else if err != nil {
-		return nil, err
-	} else {
-		var boughtProductsRes []models.BoughtProductsQuantity
-		for manufacturer, quantity := range result {
-			q, err := strconv.Atoi(quantity)
-			if err != nil {
-				client.log.Println("Unable to parse quantity to int.")
-			}
-			boughtProductsRes = append(boughtProductsRes, models.BoughtProductsQuantity{
-				Manufacturer:           manufacturer,
-				BoughtProductsQuantity: q,
-			})
-		}
-		return boughtProductsRes, nil
 	}
+	return result, err
 }
 
-func (client *Client) GetBoughtItemsCache() ([]models.BoughtItemsQuantity, error) {
-	result, err := client.rds.HGetAll(client.ctx, "items:bought").Result()
-	if err == redis.Nil {
-		return nil, nil
-	} else if err != nil {
-		return nil, err
-	} else {
-		var boughtItemsRes []models.BoughtItemsQuantity
-		for manufacturer, quantity := range result {
-			q, err := strconv.Atoi(quantity)
-			if err != nil {
-				client.log.Println("Unable to parse quantity to int.")
-			}
-			boughtItemsRes = append(boughtItemsRes, models.BoughtItemsQuantity{
-				Manufacturer:        manufacturer,
-				BoughtItemsQuantity: q,
-			})
+// SetCache atomically replaces the cache hash with the fields, rows missing in the new
+// result don't linger, and readers never see a hash without expiration.
+// A pending refresh mark of the cache is cleared.
+func (client *Client) SetCache(key string, fields []string, expiresAfter time.Duration) error {
+	_, err := client.rds.TxPipelined(client.ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(client.ctx, key, "refreshing:"+key)
+		if len(fields) != 0 {
+			pipe.HSet(client.ctx, key, fields)
+			pipe.Expire(client.ctx, key, expiresAfter)
 		}
-		return boughtItemsRes, nil
-	}
+		return nil
+	})
+	return err
 }
 
-func (client *Client) SetBoughtProductsCache(boughtProducts []models.BoughtProductsQuantity, expiresAfter time.Duration) error {
-	var input []string
-	for _, item := range boughtProducts {
-		input = append(input, item.Manufacturer)
-		input = append(input, strconv.Itoa(item.BoughtProductsQuantity))
-	}
-	if err := client.rds.HSet(client.ctx, "products:bought", input).Err(); err != nil {
+// SetReportRefresh saves the last refresh time and duration of the report cache.
+func (client *Client) SetReportRefresh(refresh models.ReportRefresh) error {
+	data, err := json.Marshal(refresh)
+	if err != nil {
 		return err
 	}
-	return client.rds.Expire(client.ctx, "products:bought", expiresAfter).Err()
+	return client.rds.HSet(client.ctx, "reports:refreshes", refresh.CacheKey, data).Err()
 }
 
-func (client *Client) SetBoughtItemsCache(boughtItems []models.BoughtItemsQuantity, expiresAfter time.Duration) error {
-	var input []string
-	for _, item := range boughtItems {
-		input = append(input, item.Manufacturer)
-		input = append(input, strconv.Itoa(item.BoughtItemsQuantity))
+func (client *Client) GetReportRefreshes() ([]models.ReportRefresh, error) {
+	result, err := client.rds.HGetAll(client.ctx, "reports:refreshes").Result()
+	if err != nil {
+		return nil, err
 	}
-	if err := client.rds.HSet(client.ctx, "items:bought", input).Err(); err != nil {
-		return err
+	refreshes := make([]models.ReportRefresh, 0, len(result))
+	for _, value := range result {
+		var refresh models.ReportRefresh
+		if err := json.Unmarshal([]byte(value), &refresh); err != nil {
+			client.log.Printf("Unable to unmarshal report refresh: %s\n", err)
+			continue
+		}
+		refreshes = append(refreshes, refresh)
 	}
-	return client.rds.Expire(client.ctx, "items:bought", expiresAfter).Err()
+	return refreshes, nil
+}
+
+// DeleteCaches deletes cache keys matching the pattern.
+func (client *Client) DeleteCaches(pattern string) (int, error) {
+	count := 0
+	iter := client.rds.Scan(client.ctx, 0, pattern, 100).Iterator()
+	for iter.Next(client.ctx) {
+		if err := client.rds.Del(client.ctx, iter.Val()).Err(); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, iter.Err()
+}
+
+func (client *Client) ClearRefreshing(key string) error {
+	return client.rds.Del(client.ctx, "refreshing:"+key).Err()
+}
+
+// MarkRefreshing returns false if a refresh of the cache is already requested.
+func (client *Client) MarkRefreshing(key string, expiresAfter time.Duration) (bool, error) {
+	return client.rds.SetNX(client.ctx, "refreshing:"+key, 1, expiresAfter).Result()
 }
 
 func (client *Client) PutRequestToQueue(request string) error {
 	return client.rds.RPush(client.ctx, "requests", request).Err()
 }
 
-func (client *Client) PopRequestFromQueue() (string, error) {
-	result, err := client.rds.BLPop(client.ctx, 60*time.Second, "requests").Result()
+// ReturnRequestToQueue puts the request to the head of the queue, so it's processed first.
+func (client *Client) ReturnRequestToQueue(request string) error {
+	return client.rds.LPush(client.ctx, "requests", request).Err()
+}
+
+// GetQueuePosition returns the number of requests ahead of the request in the queue.
+// It returns false if the request isn't in the queue.
+func (client *Client) GetQueuePosition(request string) (int64, bool, error) {
+	position, err := client.rds.LPos(client.ctx, "requests", request, redis.LPosArgs{}).Result()
+	if err == redis.Nil {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	return position, true, nil
+}
+
+func (client *Client) PopRequestFromQueue(timeout time.Duration) (string, error) {
+	result, err := client.rds.BLPop(client.ctx, timeout, "requests").Result()
 	if err == redis.Nil {
 		return "", nil
 	} else if err != nil {
@@ -106,54 +129,439 @@ func (client *Client) PopRequestFromQueue() (string, error) {
 	return result[1], nil
 }
 
+// PopRequestToProcessing atomically moves the next request to the worker processing list,
+// so the request isn't lost if the worker dies before acknowledging it.
+func (client *Client) PopRequestToProcessing(workerID string, timeout time.Duration) (string, error) {
+	result, err := client.rds.BLMove(client.ctx, "requests", "processing:"+workerID,
+		"LEFT", "RIGHT", timeout).Result()
+	if err == redis.Nil {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return result, nil
+}
+
+func (client *Client) AckRequest(workerID, request string) error {
+	return client.rds.LRem(client.ctx, "processing:"+workerID, 1, request).Err()
+}
+
+// Heartbeat registers the worker and sets its heartbeat at once, so reapers never see
+// a registered worker without a heartbeat.
+func (client *Client) Heartbeat(workerID string, expiresAfter time.Duration) error {
+	_, err := client.rds.TxPipelined(client.ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(client.ctx, "heartbeat:"+workerID, time.Now().Unix(), expiresAfter)
+		pipe.SAdd(client.ctx, "workers", workerID)
+		return nil
+	})
+	return err
+}
+
+func (client *Client) RemoveHeartbeat(workerID string) error {
+	return client.rds.Del(client.ctx, "heartbeat:"+workerID).Err()
+}
+
+func (client *Client) GetWorkers() ([]string, error) {
+	return client.rds.SMembers(client.ctx, "workers").Result()
+}
+
+func (client *Client) IsWorkerAlive(workerID string) (bool, error) {
+	count, err := client.rds.Exists(client.ctx, "heartbeat:"+workerID).Result()
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// RequeueProcessing moves in-flight requests of a dead worker back to the head of the queue
+// and forgets the worker.
+func (client *Client) RequeueProcessing(workerID string) (int, error) {
+	count := 0
+	for {
+		err := client.rds.LMove(client.ctx, "processing:"+workerID, "requests", "RIGHT", "LEFT").Err()
+		if err == redis.Nil {
+			break
+		} else if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, client.rds.SRem(client.ctx, "workers", workerID).Err()
+}
+
+// ScheduleRequest puts the request to the delayed queue, it is moved to the queue when it's due.
+func (client *Client) ScheduleRequest(request string, dueAt time.Time) error {
+	return client.rds.ZAdd(client.ctx, "requests:delayed", &redis.Z{
+		Score:  float64(dueAt.UnixNano() / int64(time.Millisecond)),
+		Member: request,
+	}).Err()
+}
+
+var promoteDueRequestsScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, request in ipairs(due) do
+	redis.call('RPUSH', KEYS[2], request)
+	redis.call('ZREM', KEYS[1], request)
+end
+return due`)
+
+// PromoteDueRequests moves at most limit due requests from the delayed queue to the queue
+// and returns the moved requests.
+func (client *Client) PromoteDueRequests(now time.Time, limit int) ([]string, error) {
+	return promoteDueRequestsScript.Run(client.ctx, client.rds,
+		[]string{"requests:delayed", "requests"}, now.UnixNano()/int64(time.Millisecond), limit).StringSlice()
+}
+
+// AddDeadLetter stores the dead letter in the "deadletters" hash and indexes it by failure time.
+func (client *Client) AddDeadLetter(deadLetter *queue.DeadLetter) error {
+	data, err := json.Marshal(deadLetter)
+	if err != nil {
+		return err
+	}
+	_, err = client.rds.TxPipelined(client.ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(client.ctx, "deadletters", deadLetter.ID, data)
+		pipe.ZAdd(client.ctx, "deadletters:index", &redis.Z{
+			Score:  float64(deadLetter.FailedAt.Unix()),
+			Member: deadLetter.ID,
+		})
+		return nil
+	})
+	return err
+}
+
+// GetDeadLetters returns dead letters starting with the most recent failures.
+func (client *Client) GetDeadLetters(offset, limit int) ([]*queue.DeadLetter, error) {
+	ids, err := client.rds.ZRevRange(client.ctx, "deadletters:index", int64(offset), int64(offset+limit-1)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return []*queue.DeadLetter{}, nil
+	}
+	values, err := client.rds.HMGet(client.ctx, "deadletters", ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+	deadLetters := make([]*queue.DeadLetter, 0, len(values))
+	for _, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var deadLetter queue.DeadLetter
+		if err := json.Unmarshal([]byte(data), &deadLetter); err != nil {
+			client.log.Printf("Unable to unmarshal dead letter: %s\n", err)
+			continue
+		}
+		deadLetters = append(deadLetters, &deadLetter)
+	}
+	return deadLetters, nil
+}
+
+// GetDeadLetter returns nil if there is no dead letter with the id.
+func (client *Client) GetDeadLetter(id string) (*queue.DeadLetter, error) {
+	data, err := client.rds.HGet(client.ctx, "deadletters", id).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var deadLetter queue.DeadLetter
+	if err := json.Unmarshal([]byte(data), &deadLetter); err != nil {
+		return nil, err
+	}
+	return &deadLetter, nil
+}
+
+// DeleteDeadLetter returns false if there was no dead letter with the id.
+func (client *Client) DeleteDeadLetter(id string) (bool, error) {
+	var deleted *redis.IntCmd
+	_, err := client.rds.TxPipelined(client.ctx, func(pipe redis.Pipeliner) error {
+		deleted = pipe.HDel(client.ctx, "deadletters", id)
+		pipe.ZRem(client.ctx, "deadletters:index", id)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return deleted.Val() > 0, nil
+}
+
+func (client *Client) PurgeDeadLetters() error {
+	return client.rds.Del(client.ctx, "deadletters", "deadletters:index").Err()
+}
+
+var claimInflightScript = redis.NewScript(`
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'EX', ARGV[3]) then
+	return 1
+end
+redis.call('SADD', KEYS[2], ARGV[2])
+redis.call('EXPIRE', KEYS[2], ARGV[3])
+return 0`)
+
+// ClaimInflight marks the query as running by the worker. If an identical query is already
+// running, the reply topic is added to its waiters and false is returned.
+func (client *Client) ClaimInflight(key, workerID, replyTo string, expiresAfter time.Duration) (bool, error) {
+	claimed, err := claimInflightScript.Run(client.ctx, client.rds,
+		[]string{"inflight:" + key, "waiters:" + key}, workerID, replyTo, int(expiresAfter.Seconds())).Int()
+	if err != nil {
+		return false, err
+	}
+	return claimed == 1, nil
+}
+
+// ReleaseInflight allows another worker to run the query, waiters are kept for it.
+func (client *Client) ReleaseInflight(key string) error {
+	return client.rds.Del(client.ctx, "inflight:"+key).Err()
+}
+
+var finishInflightScript = redis.NewScript(`
+local waiters = redis.call('SMEMBERS', KEYS[2])
+redis.call('DEL', KEYS[1], KEYS[2])
+return waiters`)
+
+// FinishInflight marks the query as finished and returns reply topics of its waiters.
+func (client *Client) FinishInflight(key string) ([]string, error) {
+	return finishInflightScript.Run(client.ctx, client.rds,
+		[]string{"inflight:" + key, "waiters:" + key}).StringSlice()
+}
+
 func (client *Client) PublishResult(topic, message string) error {
 	return client.rds.Publish(client.ctx, topic, message).Err()
 }
 
-func (client *Client) SubscribeForResult(topic string, timeout int) (string, error) {
+// Subscription waits for a result published to a topic.
+type Subscription struct {
+	client *Client
+	pubsub *redis.PubSub
+	closed chan struct{}
+}
+
+// SubscribeForResult subscribes to the topic. It returns when the subscription is confirmed,
+// so a request must be put to a queue after it to not miss a fast result.
+func (client *Client) SubscribeForResult(topic string) (*Subscription, error) {
 	pubsub := client.rds.Subscribe(client.ctx, topic)
+	if _, err := pubsub.Receive(client.ctx); err != nil {
+		_ = pubsub.Close()
+		return nil, err
+	}
+	return &Subscription{
+		client: client,
+		pubsub: pubsub,
+		closed: make(chan struct{}),
+	}, nil
+}
 
-	var message *redis.Message
+func (subscription *Subscription) Wait(timeout int) (string, error) {
 	select {
-	case message = <-pubsub.Channel():
-		client.log.Printf("Received message: %s\n", message.Payload)
+	case message := <-subscription.pubsub.Channel():
+		subscription.client.log.Printf("Received message of %d bytes.\n", len(message.Payload))
+		return message.Payload, nil
 	case <-time.After(time.Duration(timeout) * time.Second):
-		client.log.Printf("Receiving message time out.")
+		subscription.client.log.Printf("Receiving message time out.")
 		return "", e.SubscribeTimeoutError{}
 	}
+}
+
+// Messages returns the channel of messages published to the topic, it is closed with the subscription.
+func (subscription *Subscription) Messages() <-chan string {
+	messages := make(chan string)
+	go func() {
+		defer close(messages)
+		for message := range subscription.pubsub.Channel() {
+			select {
+			case messages <- message.Payload:
+			case <-subscription.closed:
+				return
+			}
+		}
+	}()
+	return messages
+}
+
+func (subscription *Subscription) Close() {
+	close(subscription.closed)
+	if err := subscription.pubsub.Close(); err != nil {
+		subscription.client.log.Printf("Unable to close subscription: %s\n", err)
+	}
+}
 
-	if err := pubsub.Close(); err != nil {
-		return "", err
+// CreateJob stores the job state, it expires with the job.
+func (client *Client) CreateJob(job *queue.Job, expiresAfter time.Duration) error {
+	fields, err := job.Fields()
+	if err != nil {
+		return err
 	}
+	_, err = client.rds.TxPipelined(client.ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(client.ctx, "job:"+job.ID, fields)
+		pipe.Expire(client.ctx, "job:"+job.ID, expiresAfter)
+		return nil
+	})
+	return err
+}
+
+var updateJobScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[1], unpack(ARGV))
+return 1`)
 
-	return message.Payload, nil
+// UpdateJob sets the job fields. It returns false and doesn't recreate the job if it has expired.
+func (client *Client) UpdateJob(id string, fields map[string]interface{}) (bool, error) {
+	args := make([]interface{}, 0, 2*len(fields))
+	for name, value := range fields {
+		args = append(args, name, value)
+	}
+	updated, err := updateJobScript.Run(client.ctx, client.rds, []string{"job:" + id}, args...).Int()
+	if err != nil {
+		return false, err
+	}
+	return updated == 1, nil
 }
 
-func (client *Client) GetLockCounter(token string) (int, error) {
-	res, err := client.rds.Get(client.ctx, "lock:"+token).Result()
-	if err == redis.Nil {
-		return 0, nil
+var updateJobFromScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'status') ~= ARGV[1] then
+	return 0
+end
+redis.call('HSET', KEYS[1], unpack(ARGV, 2))
+return 1`)
+
+// UpdateJobFrom sets the job fields if the job has the status. It returns false if the status
+// has changed meanwhile or the job has expired.
+func (client *Client) UpdateJobFrom(id, status string, fields map[string]interface{}) (bool, error) {
+	args := make([]interface{}, 0, 1+2*len(fields))
+	args = append(args, status)
+	for name, value := range fields {
+		args = append(args, name, value)
+	}
+	updated, err := updateJobFromScript.Run(client.ctx, client.rds, []string{"job:" + id}, args...).Int()
+	if err != nil {
+		return false, err
+	}
+	return updated == 1, nil
+}
+
+// GetJob returns nil if there is no job with the id.
+func (client *Client) GetJob(id string) (*queue.Job, error) {
+	fields, err := client.rds.HGetAll(client.ctx, "job:"+id).Result()
+	if err != nil {
+		return nil, err
 	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return queue.DecodeJob(id, fields)
+}
+
+// CacheAuthentication saves the principal authenticated by the key hash,
+// a nil principal saves that the key is invalid.
+func (client *Client) CacheAuthentication(hash string, principal *models.Principal, expiresAfter time.Duration) error {
+	data, err := json.Marshal(principal)
 	if err != nil {
-		return 0, err
+		return err
 	}
-	return strconv.Atoi(res)
+	return client.rds.Set(client.ctx, "auth:"+hash, data, expiresAfter).Err()
 }
 
-func (client *Client) Lock(token string) error {
-	return client.rds.IncrBy(client.ctx, "lock:"+token, 1).Err()
+// GetCachedAuthentication returns false if the key hash isn't cached. A cached invalid key
+// is returned as a nil principal.
+func (client *Client) GetCachedAuthentication(hash string) (*models.Principal, bool, error) {
+	data, err := client.rds.Get(client.ctx, "auth:"+hash).Result()
+	if err == redis.Nil {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	var principal *models.Principal
+	if err := json.Unmarshal([]byte(data), &principal); err != nil {
+		return nil, false, err
+	}
+	return principal, true, nil
 }
 
-func (client *Client) Unlock(token string) error {
-	return client.rds.DecrBy(client.ctx, "lock:"+token, 1).Err()
+func (client *Client) DeleteCachedAuthentication(hash string) error {
+	return client.rds.Del(client.ctx, "auth:"+hash).Err()
 }
 
-func (client *Client) GetRetryCount(token string) (int, error) {
-	res, err := client.rds.IncrBy(client.ctx, "retry:"+token, 1).Result()
+// NewQuotas returns the daily quotas kept in this redis.
+func (client *Client) NewQuotas() *ratelimit.Quotas {
+	return ratelimit.NewQuotas(client.ctx, client.rds)
+}
+
+func (client *Client) SavePlan(plan ratelimit.Plan) error {
+	data, err := json.Marshal(plan)
 	if err != nil {
-		return 0, err
+		return err
+	}
+	return client.rds.HSet(client.ctx, "plans", plan.Name, data).Err()
+}
+
+func (client *Client) GetPlans() ([]ratelimit.Plan, error) {
+	values, err := client.rds.HGetAll(client.ctx, "plans").Result()
+	if err != nil {
+		return nil, err
+	}
+	plans := make([]ratelimit.Plan, 0, len(values))
+	for _, data := range values {
+		var plan ratelimit.Plan
+		if err := json.Unmarshal([]byte(data), &plan); err != nil {
+			client.log.Printf("Unable to unmarshal plan: %s\n", err)
+			continue
+		}
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
+
+// GetPlan returns nil if there is no plan with the name.
+func (client *Client) GetPlan(name string) (*ratelimit.Plan, error) {
+	data, err := client.rds.HGet(client.ctx, "plans", name).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
 	}
-	return int(res), nil
+	var plan ratelimit.Plan
+	if err := json.Unmarshal([]byte(data), &plan); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// DeletePlan returns false if there was no plan with the name.
+func (client *Client) DeletePlan(name string) (bool, error) {
+	deleted, err := client.rds.HDel(client.ctx, "plans", name).Result()
+	return deleted > 0, err
+}
+
+func (client *Client) AssignPlan(token, name string) error {
+	return client.rds.HSet(client.ctx, "plans:tokens", token, name).Err()
+}
+
+// UnassignPlan returns false if the token had no assigned plan.
+func (client *Client) UnassignPlan(token string) (bool, error) {
+	deleted, err := client.rds.HDel(client.ctx, "plans:tokens", token).Result()
+	return deleted > 0, err
+}
+
+// GetAssignedPlan returns the name of the plan assigned to the token, it is empty if there is none.
+func (client *Client) GetAssignedPlan(token string) (string, error) {
+	name, err := client.rds.HGet(client.ctx, "plans:tokens", token).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return name, err
+}
+
+// NewLimiter returns a rate limiter keeping its counters in this redis.
+func (client *Client) NewLimiter(algorithm, prefix string) (*ratelimit.Limiter, error) {
+	return ratelimit.NewLimiter(client.ctx, client.rds, algorithm, prefix)
+}
+
+// NewLeases returns the concurrency leases kept in this redis.
+func (client *Client) NewLeases() *ratelimit.Leases {
+	return ratelimit.NewLeases(client.ctx, client.rds)
 }
 
 func NewRedisClient(ctx context.Context, log *log.Logger, config *config.AppConfig) *Client {
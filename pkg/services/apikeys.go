@@ -0,0 +1,173 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+	"warehouse-system/config"
+	e "warehouse-system/errors"
+	"warehouse-system/pkg/models"
+	"warehouse-system/pkg/postgres"
+	"warehouse-system/pkg/redis"
+)
+
+const (
+	// apiKeyPrefix marks keys issued by this service
+	apiKeyPrefix = "wh_"
+	// apiKeyShownPrefix is the length of the key prefix stored to tell keys apart
+	apiKeyShownPrefix = len(apiKeyPrefix) + 8
+)
+
+type APIKeyService struct {
+	log            *log.Logger
+	config         *config.AppConfig
+	redisClient    *redis.Client
+	postgresClient *postgres.Client
+}
+
+// Authenticate returns the principal of the key. Validation results are cached in redis for
+// the auth cache TTL, but not beyond the expiration of the key. Invalid keys are cached for
+// the shorter auth failure cache TTL, as callers may try any number of them.
+func (as *APIKeyService) Authenticate(ctx context.Context, token string) (*models.Principal, error) {
+	hash := hashAPIKey(token)
+	principal, cached, err := as.redisClient.GetCachedAuthentication(hash)
+	if err != nil {
+		as.log.Printf("Unable to get cached authentication: %s\n", err)
+	}
+	// principals cached before tenants were introduced have no tenant
+	if !cached || (principal != nil && principal.Tenant == "") {
+		if principal, err = as.validate(ctx, hash); err != nil {
+			return nil, err
+		}
+	}
+	if principal == nil {
+		return nil, e.UnauthorizedError{Message: "invalid token"}
+	}
+	return principal, nil
+}
+
+// validate looks up the key in postgres and caches the result.
+func (as *APIKeyService) validate(ctx context.Context, hash string) (*models.Principal, error) {
+	key, err := as.postgresClient.GetAPIKeyByHash(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expiresAfter := time.Duration(as.config.AuthFailureCacheTTL) * time.Second
+	var principal *models.Principal
+	if key != nil && key.Active(now) {
+		principal = &models.Principal{
+			ID:       ClientPrincipalID(key.Tenant, key.ClientID),
+			ClientID: key.ClientID,
+			Tenant:   key.Tenant,
+			KeyID:    key.ID,
+			Scopes:   key.Scopes,
+		}
+		expiresAfter = time.Duration(as.config.AuthCacheTTL) * time.Second
+		if key.ExpiresAt != nil && key.ExpiresAt.Sub(now) < expiresAfter {
+			expiresAfter = key.ExpiresAt.Sub(now)
+		}
+	}
+	if err := as.redisClient.CacheAuthentication(hash, principal, expiresAfter); err != nil {
+		as.log.Printf("Unable to cache authentication: %s\n", err)
+	}
+	return principal, nil
+}
+
+// Create issues a key with the scopes to the client of the tenant, a key without scopes can read
+// reports. The returned token is shown once, only its hash is stored.
+func (as *APIKeyService) Create(ctx context.Context, tenant, clientID, name string, scopes []string,
+	expiresAt *time.Time) (*models.APIKey, string, error) {
+	if tenant == "" || clientID == "" || name == "" {
+		return nil, "", e.BadRequestError{Message: "tenant, client id and name must be provided"}
+	}
+	if len(scopes) == 0 {
+		scopes = []string{models.ScopeReportsRead}
+	}
+	for _, scope := range scopes {
+		if !models.ValidScope(scope) {
+			return nil, "", e.BadRequestError{Message: "unknown scope " + scope}
+		}
+		if scope == models.ScopeAdmin {
+			return nil, "", e.BadRequestError{Message: "admin scope is reserved to the admin token"}
+		}
+	}
+	if expiresAt != nil {
+		if !expiresAt.After(time.Now()) {
+			return nil, "", e.BadRequestError{Message: "expiration time must be in the future"}
+		}
+		utc := expiresAt.UTC()
+		expiresAt = &utc
+	}
+
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+	token := apiKeyPrefix + hex.EncodeToString(secret)
+
+	key, err := as.postgresClient.CreateAPIKey(ctx, models.APIKey{
+		ClientID:  clientID,
+		Tenant:    tenant,
+		Name:      name,
+		Prefix:    token[:apiKeyShownPrefix],
+		Hash:      hashAPIKey(token),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if key == nil {
+		return nil, "", e.NotFoundError{Message: fmt.Sprintf("client %s of tenant %s not found", clientID, tenant)}
+	}
+	// the key may have been tried before it was issued
+	if err := as.redisClient.DeleteCachedAuthentication(key.Hash); err != nil {
+		as.log.Printf("Unable to delete cached authentication: %s\n", err)
+	}
+	return key, token, nil
+}
+
+// List returns keys of the client of the tenant. Empty filters match all tenants or all clients.
+func (as *APIKeyService) List(ctx context.Context, tenant, clientID string) ([]models.APIKey, error) {
+	return as.postgresClient.GetAPIKeys(ctx, tenant, clientID)
+}
+
+// Revoke revokes the key, it is rejected immediately as the cached validation result is removed.
+func (as *APIKeyService) Revoke(ctx context.Context, id int) error {
+	hash, err := as.postgresClient.RevokeAPIKey(ctx, id)
+	if err != nil {
+		return err
+	}
+	if hash == nil {
+		return e.NotFoundError{Message: fmt.Sprintf("active api key %d not found", id)}
+	}
+	return as.redisClient.DeleteCachedAuthentication(*hash)
+}
+
+// ClientPrincipalID is the principal id of the client of the tenant. It is prefixed, so a client
+// never gets the id of the system token, and it has the tenant, so clients of different tenants
+// never share jobs, plans or rate limits.
+func ClientPrincipalID(tenant, clientID string) string {
+	return "client:" + tenant + ":" + clientID
+}
+
+func hashAPIKey(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func NewAPIKeyService(log *log.Logger, config *config.AppConfig, redisClient *redis.Client,
+	postgresClient *postgres.Client) *APIKeyService {
+	return &APIKeyService{
+		log:            log,
+		config:         config,
+		redisClient:    redisClient,
+		postgresClient: postgresClient,
+	}
+}
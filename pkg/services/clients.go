@@ -0,0 +1,159 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/mail"
+	"regexp"
+	"strconv"
+	e "warehouse-system/errors"
+	"warehouse-system/pkg/models"
+	"warehouse-system/pkg/postgres"
+	"warehouse-system/pkg/redis"
+)
+
+// phonePattern is an E.164 phone number, it fits the phone column.
+var phonePattern = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+
+// ClientService manages clients of tenants. A tenant never sees clients of other tenants.
+type ClientService struct {
+	log            *log.Logger
+	redisClient    *redis.Client
+	postgresClient *postgres.Client
+}
+
+func (cs *ClientService) Create(ctx context.Context, tenant string, client models.Client) (*models.Client, error) {
+	if client.ExternalID == "" || len(client.ExternalID) > 64 {
+		return nil, e.BadRequestError{Message: "external id must have 1 to 64 characters"}
+	}
+	if err := validateClient(client); err != nil {
+		return nil, err
+	}
+	created, err := cs.postgresClient.CreateClient(ctx, tenant, client)
+	if err != nil {
+		return nil, err
+	}
+	// bearer tokens of the client may have been tried before it was created
+	cs.deleteCachedAuthentications(jwtSubjectHash(client.ExternalID, ""), jwtSubjectHash(client.ExternalID, tenant))
+	return created, nil
+}
+
+func (cs *ClientService) Get(ctx context.Context, tenant, externalID string) (*models.Client, error) {
+	client, err := cs.postgresClient.GetClient(ctx, tenant, externalID)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, e.NotFoundError{Message: fmt.Sprintf("client %s not found", externalID)}
+	}
+	return client, nil
+}
+
+// List returns a page of clients of the tenant in creation order, starting after the cursor
+// of the previous page or from the first client if the cursor is empty.
+func (cs *ClientService) List(ctx context.Context, tenant, cursor string, limit int) (*models.ClientsPage, error) {
+	afterID := 0
+	if cursor != "" {
+		var err error
+		if afterID, err = decodeCursor(cursor); err != nil {
+			return nil, e.BadRequestError{Message: "invalid cursor"}
+		}
+	}
+
+	// one more client tells whether there is a next page
+	clients, err := cs.postgresClient.GetClients(ctx, tenant, afterID, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	page := &models.ClientsPage{Clients: clients}
+	if len(clients) > limit {
+		page.Clients = clients[:limit]
+		page.NextCursor = encodeCursor(page.Clients[limit-1].ID)
+	}
+	return page, nil
+}
+
+// Update replaces the username, phone and email of the client, the external id can't be changed.
+func (cs *ClientService) Update(ctx context.Context, tenant string, client models.Client) (*models.Client, error) {
+	if err := validateClient(client); err != nil {
+		return nil, err
+	}
+	updated, err := cs.postgresClient.UpdateClient(ctx, tenant, client)
+	if err != nil {
+		return nil, err
+	}
+	if updated == nil {
+		return nil, e.NotFoundError{Message: fmt.Sprintf("client %s not found", client.ExternalID)}
+	}
+	return updated, nil
+}
+
+// Delete deletes the client with its orders and api keys, the keys and bearer tokens of the client
+// are rejected immediately as their cached validation results are removed.
+func (cs *ClientService) Delete(ctx context.Context, tenant, externalID string) error {
+	deleted, hashes, err := cs.postgresClient.DeleteClient(ctx, tenant, externalID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return e.NotFoundError{Message: fmt.Sprintf("client %s not found", externalID)}
+	}
+	hashes = append(hashes, jwtSubjectHash(externalID, ""), jwtSubjectHash(externalID, tenant))
+	cs.deleteCachedAuthentications(hashes...)
+	return nil
+}
+
+func (cs *ClientService) deleteCachedAuthentications(hashes ...string) {
+	for _, hash := range hashes {
+		if err := cs.redisClient.DeleteCachedAuthentication(hash); err != nil {
+			cs.log.Printf("Unable to delete cached authentication: %s\n", err)
+		}
+	}
+}
+
+// validateClient checks the fields a client may change, their limits are the column sizes.
+func validateClient(client models.Client) error {
+	if client.Username == "" || len(client.Username) > 128 {
+		return e.BadRequestError{Message: "username must have 1 to 128 characters"}
+	}
+	if !phonePattern.MatchString(client.Phone) {
+		return e.BadRequestError{Message: "phone must be an E.164 number, e.g. +14155552671"}
+	}
+	if client.Email != "" {
+		address, err := mail.ParseAddress(client.Email)
+		if err != nil || address.Address != client.Email || len(client.Email) > 128 {
+			return e.BadRequestError{Message: "email must be a valid address of up to 128 characters"}
+		}
+	}
+	return nil
+}
+
+// encodeCursor makes the cursor of the page ending with the client id opaque.
+func encodeCursor(id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(id)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.Atoi(string(data))
+	if err != nil {
+		return 0, err
+	}
+	if id < 1 {
+		return 0, fmt.Errorf("cursor id %d is not positive", id)
+	}
+	return id, nil
+}
+
+func NewClientService(log *log.Logger, redisClient *redis.Client, postgresClient *postgres.Client) *ClientService {
+	return &ClientService{
+		log:            log,
+		redisClient:    redisClient,
+		postgresClient: postgresClient,
+	}
+}
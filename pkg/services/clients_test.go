@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	e "warehouse-system/errors"
+	"warehouse-system/pkg/models"
+)
+
+func TestValidateClient(t *testing.T) {
+	valid := models.Client{Username: "john", Phone: "+14155552671", Email: "john@example.com"}
+
+	tests := []struct {
+		name  string
+		edit  func(c *models.Client)
+		valid bool
+	}{
+		{"valid", func(c *models.Client) {}, true},
+		{"no email", func(c *models.Client) { c.Email = "" }, true},
+		{"shortest phone", func(c *models.Client) { c.Phone = "+12" }, true},
+		{"longest phone", func(c *models.Client) { c.Phone = "+123456789012345" }, true},
+		{"no username", func(c *models.Client) { c.Username = "" }, false},
+		{"long username", func(c *models.Client) { c.Username = strings.Repeat("a", 129) }, false},
+		{"no phone", func(c *models.Client) { c.Phone = "" }, false},
+		{"phone without plus", func(c *models.Client) { c.Phone = "14155552671" }, false},
+		{"phone with leading zero", func(c *models.Client) { c.Phone = "+04155552671" }, false},
+		{"phone with spaces", func(c *models.Client) { c.Phone = "+1 415 555 2671" }, false},
+		{"phone with letters", func(c *models.Client) { c.Phone = "+1415555267a" }, false},
+		{"long phone", func(c *models.Client) { c.Phone = "+1234567890123456" }, false},
+		{"email without at", func(c *models.Client) { c.Email = "john.example.com" }, false},
+		{"email with name", func(c *models.Client) { c.Email = "John <john@example.com>" }, false},
+		{"email with spaces", func(c *models.Client) { c.Email = " john@example.com" }, false},
+		{"long email", func(c *models.Client) { c.Email = strings.Repeat("a", 117) + "@example.com" }, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := valid
+			test.edit(&client)
+			err := validateClient(client)
+			if test.valid && err != nil {
+				t.Fatalf("validateClient(%+v) = %s, want nil", client, err)
+			}
+			var badRequestErr e.BadRequestError
+			if !test.valid && !errors.As(err, &badRequestErr) {
+				t.Fatalf("validateClient(%+v) = %v, want a bad request error", client, err)
+			}
+		})
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	for _, id := range []int{1, 42, 1 << 31} {
+		cursor := encodeCursor(id)
+		decoded, err := decodeCursor(cursor)
+		if err != nil {
+			t.Fatalf("decodeCursor(%q) = %s", cursor, err)
+		}
+		if decoded != id {
+			t.Fatalf("decodeCursor(encodeCursor(%d)) = %d", id, decoded)
+		}
+	}
+}
+
+func TestCursorTampering(t *testing.T) {
+	encode := func(s string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"not base64", "not a cursor!"},
+		{"padded", encode("42") + "=="},
+		{"not a number", encode("abc")},
+		{"trailing characters", encode("42;DROP")},
+		{"zero", encode("0")},
+		{"negative", encode("-1")},
+		{"overflow", encode("99999999999999999999")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if id, err := decodeCursor(test.cursor); err == nil {
+				t.Fatalf("decodeCursor(%q) = %d, want an error", test.cursor, id)
+			}
+			// the cursor is rejected before the database is queried
+			cs := &ClientService{}
+			_, err := cs.List(context.Background(), models.DefaultTenant, test.cursor, 10)
+			var badRequestErr e.BadRequestError
+			if !errors.As(err, &badRequestErr) {
+				t.Fatalf("List with cursor %q = %v, want a bad request error", test.cursor, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	e "warehouse-system/errors"
+	"warehouse-system/pkg/queue"
+	"warehouse-system/pkg/redis"
+)
+
+type DeadLetterService struct {
+	log         *log.Logger
+	redisClient *redis.Client
+}
+
+func (ds *DeadLetterService) List(offset, limit int) ([]*queue.DeadLetter, error) {
+	return ds.redisClient.GetDeadLetters(offset, limit)
+}
+
+func (ds *DeadLetterService) Get(id string) (*queue.DeadLetter, error) {
+	deadLetter, err := ds.redisClient.GetDeadLetter(id)
+	if err != nil {
+		return nil, err
+	}
+	if deadLetter == nil {
+		return nil, e.NotFoundError{Message: fmt.Sprintf("dead letter %s not found", id)}
+	}
+	return deadLetter, nil
+}
+
+// Replay puts the dead-lettered request back to the queue and removes it from the store.
+func (ds *DeadLetterService) Replay(id string) error {
+	deadLetter, err := ds.Get(id)
+	if err != nil {
+		return err
+	}
+	request, err := deadLetter.Replay()
+	if err != nil {
+		return e.BadRequestError{Message: err.Error()}
+	}
+	if err := ds.redisClient.PutRequestToQueue(request); err != nil {
+		return err
+	}
+	ds.log.Printf("Dead letter %s is put to queue.\n", id)
+	_, err = ds.redisClient.DeleteDeadLetter(id)
+	return err
+}
+
+func (ds *DeadLetterService) Delete(id string) error {
+	deleted, err := ds.redisClient.DeleteDeadLetter(id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return e.NotFoundError{Message: fmt.Sprintf("dead letter %s not found", id)}
+	}
+	return nil
+}
+
+func (ds *DeadLetterService) Purge() error {
+	return ds.redisClient.PurgeDeadLetters()
+}
+
+func NewDeadLetterService(log *log.Logger, redisClient *redis.Client) *DeadLetterService {
+	return &DeadLetterService{
+		log:         log,
+		redisClient: redisClient,
+	}
+}
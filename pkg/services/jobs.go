@@ -0,0 +1,100 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"time"
+	"warehouse-system/config"
+	e "warehouse-system/errors"
+	"warehouse-system/pkg/queries"
+	"warehouse-system/pkg/queue"
+	"warehouse-system/pkg/redis"
+)
+
+type JobService struct {
+	log         *log.Logger
+	config      *config.AppConfig
+	redisClient *redis.Client
+}
+
+// CreateJob puts the report request of the tenant to a queue without waiting for the result.
+// The query worker updates the job state, it is kept for the job TTL.
+func (js *JobService) CreateJob(query *queries.Query, tenant string, params queries.Params, token string,
+	trace queue.TraceContext) (*queue.Job, error) {
+	if token == js.config.SystemToken {
+		return nil, e.BadRequestError{Message: "token is reserved"}
+	}
+	if tenant == "" {
+		return nil, e.BadRequestError{Message: "token has no tenant"}
+	}
+
+	jobTTL := time.Duration(js.config.JobTTL) * time.Second
+	job := queue.NewJob(token, tenant, query.Name, params)
+
+	// the result isn't needed after the job has expired
+	message := queue.NewJobMessage(job, job.CreatedAt.Add(jobTTL), trace)
+	request, err := message.Encode()
+	if err != nil {
+		return nil, err
+	}
+	job.Request = request
+	if err := js.redisClient.CreateJob(job, jobTTL); err != nil {
+		js.log.Printf("Unable to create job: %s\n", err)
+		return nil, err
+	}
+	if err := js.redisClient.PutRequestToQueue(request); err != nil {
+		js.log.Printf("Unable to put request to queue: %s\n", err)
+		return nil, err
+	}
+	js.log.Printf("Job %s is put to queue successfully.\n", job.ID)
+	return job, nil
+}
+
+// GetJob returns the job of the token. Jobs of other tokens are not found.
+func (js *JobService) GetJob(id, token string) (*queue.Job, error) {
+	job, err := js.redisClient.GetJob(id)
+	if err != nil {
+		return nil, err
+	}
+	if job == nil || job.Token != token {
+		return nil, e.NotFoundError{Message: fmt.Sprintf("job %s not found", id)}
+	}
+	return job, nil
+}
+
+// SubscribeEvents subscribes to the job events and returns the job state. Events published
+// after the state has been read are received by the subscription.
+func (js *JobService) SubscribeEvents(id, token string) (*queue.Job, *redis.Subscription, error) {
+	subscription, err := js.redisClient.SubscribeForResult(queue.JobEventsTopic(id))
+	if err != nil {
+		js.log.Printf("Subscribing for job events failed: %s\n", err)
+		return nil, nil, err
+	}
+	job, err := js.GetJob(id, token)
+	if err != nil {
+		subscription.Close()
+		return nil, nil, err
+	}
+	return job, subscription, nil
+}
+
+// GetQueuePosition returns the number of requests ahead of the queued job, it returns nil
+// if the job request isn't in the queue.
+func (js *JobService) GetQueuePosition(job *queue.Job) (*int64, error) {
+	if job.Request == "" {
+		return nil, nil
+	}
+	position, ok, err := js.redisClient.GetQueuePosition(job.Request)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return &position, nil
+}
+
+func NewJobService(log *log.Logger, config *config.AppConfig, redisClient *redis.Client) *JobService {
+	return &JobService{
+		log:         log,
+		config:      config,
+		redisClient: redisClient,
+	}
+}
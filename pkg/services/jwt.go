@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"log"
+	"strings"
+	"time"
+	"warehouse-system/config"
+	e "warehouse-system/errors"
+	"warehouse-system/pkg/jwks"
+	"warehouse-system/pkg/models"
+	"warehouse-system/pkg/postgres"
+	"warehouse-system/pkg/redis"
+)
+
+// jwtMethods are the signing methods of the keys a JWKS file may contain.
+var jwtMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// JWTService authenticates bearer tokens issued by internal services. Signatures are
+// verified with the keys of the local JWKS file, the subject is looked up in the clients.
+type JWTService struct {
+	log            *log.Logger
+	config         *config.AppConfig
+	keys           *jwks.KeySet
+	parser         *jwt.Parser
+	redisClient    *redis.Client
+	postgresClient *postgres.Client
+}
+
+// Authenticate verifies the token and returns the principal of the client of its subject.
+// Scopes are read from the configured scope claim, the tenant is the tenant of the client.
+func (js *JWTService) Authenticate(ctx context.Context, raw string) (*models.Principal, error) {
+	claims := jwt.MapClaims{}
+	if _, err := js.parser.ParseWithClaims(raw, claims, js.key); err != nil {
+		return nil, e.UnauthorizedError{Message: "invalid bearer token: " + err.Error()}
+	}
+
+	// the parser checks exp and nbf only if they are present
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, e.UnauthorizedError{Message: "bearer token must expire"}
+	}
+	if !claims.VerifyIssuer(js.config.JWTIssuer, true) {
+		return nil, e.UnauthorizedError{Message: "invalid bearer token issuer"}
+	}
+	if !claims.VerifyAudience(js.config.JWTAudience, true) {
+		return nil, e.UnauthorizedError{Message: "invalid bearer token audience"}
+	}
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, e.UnauthorizedError{Message: "bearer token must have a subject"}
+	}
+	// clients of different tenants may have the same external id, the tenant claim tells them apart
+	tenantClaim, _ := claims[js.config.JWTTenantClaim].(string)
+
+	client, err := js.client(ctx, subject, tenantClaim)
+	if err != nil {
+		return nil, err
+	}
+	principal := *client
+	principal.Scopes = js.scopes(claims[js.config.JWTScopeClaim])
+	return &principal, nil
+}
+
+// client returns the principal of the client of the subject without scopes. Lookup results,
+// including unknown subjects, are cached in redis like results of api key validation.
+func (js *JWTService) client(ctx context.Context, subject, tenantClaim string) (*models.Principal, error) {
+	hash := jwtSubjectHash(subject, tenantClaim)
+	principal, cached, err := js.redisClient.GetCachedAuthentication(hash)
+	if err != nil {
+		js.log.Printf("Unable to get cached authentication: %s\n", err)
+	}
+	if cached {
+		if principal == nil {
+			return nil, e.UnauthorizedError{Message: "bearer token subject is not a client"}
+		}
+		return principal, nil
+	}
+
+	tenants, err := js.postgresClient.GetClientTenants(ctx, subject, tenantClaim)
+	if err != nil {
+		return nil, err
+	}
+	if len(tenants) > 1 {
+		return nil, e.UnauthorizedError{Message: "bearer token subject is a client of several tenants, " +
+			"the tenant claim must be set"}
+	}
+
+	expiresAfter := time.Duration(js.config.AuthFailureCacheTTL) * time.Second
+	if len(tenants) == 1 {
+		principal = &models.Principal{
+			ID:       ClientPrincipalID(tenants[0], subject),
+			ClientID: subject,
+			Tenant:   tenants[0],
+		}
+		expiresAfter = time.Duration(js.config.AuthCacheTTL) * time.Second
+	}
+	if err := js.redisClient.CacheAuthentication(hash, principal, expiresAfter); err != nil {
+		js.log.Printf("Unable to cache authentication: %s\n", err)
+	}
+	if principal == nil {
+		return nil, e.UnauthorizedError{Message: "bearer token subject is not a client"}
+	}
+	return principal, nil
+}
+
+// key returns the JWKS key of the token key id.
+func (js *JWTService) key(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := js.keys.Key(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %s", kid)
+	}
+	return key, nil
+}
+
+// scopes parses a space-delimited scope string of OAuth 2.0 or a list of scopes.
+// Unknown scopes and the admin scope, which is reserved to the admin token, are ignored.
+func (js *JWTService) scopes(claim interface{}) []string {
+	var values []string
+	switch claim := claim.(type) {
+	case string:
+		values = strings.Fields(claim)
+	case []interface{}:
+		for _, value := range claim {
+			if scope, ok := value.(string); ok {
+				values = append(values, scope)
+			}
+		}
+	}
+
+	scopes := []string{}
+	for _, scope := range values {
+		if models.ValidScope(scope) && scope != models.ScopeAdmin {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// jwtSubjectHash is the auth cache key of the client lookup of the subject and the tenant claim.
+func jwtSubjectHash(subject, tenantClaim string) string {
+	return hashAPIKey("jwt:" + tenantClaim + ":" + subject)
+}
+
+func NewJWTService(log *log.Logger, config *config.AppConfig, keys *jwks.KeySet, redisClient *redis.Client,
+	postgresClient *postgres.Client) *JWTService {
+	return &JWTService{
+		log:            log,
+		config:         config,
+		keys:           keys,
+		parser:         jwt.NewParser(jwt.WithValidMethods(jwtMethods)),
+		redisClient:    redisClient,
+		postgresClient: postgresClient,
+	}
+}
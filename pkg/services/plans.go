@@ -0,0 +1,167 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"warehouse-system/config"
+	e "warehouse-system/errors"
+	"warehouse-system/pkg/ratelimit"
+	"warehouse-system/pkg/redis"
+)
+
+// maxCachedPlans bounds the plan cache, expired plans are dropped when it is exceeded.
+const maxCachedPlans = 10000
+
+type cachedPlan struct {
+	plan      ratelimit.Plan
+	expiresAt time.Time
+}
+
+// PlanService manages quota plans stored in redis and assigned to tokens. Tokens without
+// an assigned plan use the default plan, it is configured by the app config unless a plan
+// named default is stored.
+type PlanService struct {
+	log         *log.Logger
+	config      *config.AppConfig
+	redisClient *redis.Client
+
+	mu sync.Mutex
+	// plans caches plans of tokens, so changes of plans apply within the plan cache TTL
+	plans map[string]cachedPlan
+}
+
+// GetPlan returns the plan of the token. If the assigned plan is deleted, the default plan is used.
+func (ps *PlanService) GetPlan(token string) (ratelimit.Plan, error) {
+	now := time.Now()
+	ps.mu.Lock()
+	cached, ok := ps.plans[token]
+	ps.mu.Unlock()
+	if ok && now.Before(cached.expiresAt) {
+		return cached.plan, nil
+	}
+
+	name, err := ps.redisClient.GetAssignedPlan(token)
+	if err != nil {
+		return ratelimit.Plan{}, err
+	}
+	var plan *ratelimit.Plan
+	if name != "" {
+		if plan, err = ps.redisClient.GetPlan(name); err != nil {
+			return ratelimit.Plan{}, err
+		}
+	}
+	if plan == nil {
+		defaultPlan, err := ps.Get(ratelimit.DefaultPlan)
+		if err != nil {
+			return ratelimit.Plan{}, err
+		}
+		plan = &defaultPlan
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if len(ps.plans) >= maxCachedPlans {
+		for token, cached := range ps.plans {
+			if now.After(cached.expiresAt) {
+				delete(ps.plans, token)
+			}
+		}
+	}
+	ps.plans[token] = cachedPlan{
+		plan:      *plan,
+		expiresAt: now.Add(time.Duration(ps.config.PlanCacheTTL) * time.Second),
+	}
+	return *plan, nil
+}
+
+// List returns the stored plans and the default plan.
+func (ps *PlanService) List() ([]ratelimit.Plan, error) {
+	plans, err := ps.redisClient.GetPlans()
+	if err != nil {
+		return nil, err
+	}
+	for _, plan := range plans {
+		if plan.Name == ratelimit.DefaultPlan {
+			return plans, nil
+		}
+	}
+	return append(plans, ps.configuredPlan()), nil
+}
+
+func (ps *PlanService) Get(name string) (ratelimit.Plan, error) {
+	plan, err := ps.redisClient.GetPlan(name)
+	if err != nil {
+		return ratelimit.Plan{}, err
+	}
+	if plan != nil {
+		return *plan, nil
+	}
+	if name == ratelimit.DefaultPlan {
+		return ps.configuredPlan(), nil
+	}
+	return ratelimit.Plan{}, e.NotFoundError{Message: fmt.Sprintf("plan %s not found", name)}
+}
+
+// Save creates or replaces the plan.
+func (ps *PlanService) Save(plan ratelimit.Plan) error {
+	if err := plan.Validate(); err != nil {
+		return e.BadRequestError{Message: err.Error()}
+	}
+	return ps.redisClient.SavePlan(plan)
+}
+
+// Delete removes the plan, tokens assigned to it use the default plan.
+func (ps *PlanService) Delete(name string) error {
+	deleted, err := ps.redisClient.DeletePlan(name)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return e.NotFoundError{Message: fmt.Sprintf("plan %s not found", name)}
+	}
+	return nil
+}
+
+func (ps *PlanService) Assign(token, name string) error {
+	if _, err := ps.Get(name); err != nil {
+		return err
+	}
+	return ps.redisClient.AssignPlan(token, name)
+}
+
+// Unassign makes the token use the default plan.
+func (ps *PlanService) Unassign(token string) error {
+	unassigned, err := ps.redisClient.UnassignPlan(token)
+	if err != nil {
+		return err
+	}
+	if !unassigned {
+		return e.NotFoundError{Message: "token has no assigned plan"}
+	}
+	return nil
+}
+
+// configuredPlan is the default plan of the app config.
+func (ps *PlanService) configuredPlan() ratelimit.Plan {
+	return ratelimit.Plan{
+		Name:              ratelimit.DefaultPlan,
+		Concurrency:       ps.config.QueryConcurrency,
+		RateRequests:      ps.config.RateLimitRequests,
+		QueryRateRequests: ps.config.QueryRateLimitRequests,
+		RatePeriod:        ps.config.RateLimitPeriod,
+		Burst:             ps.config.RateLimitBurst,
+		DailyQuota:        ps.config.DailyQuota,
+		MaxRetryCount:     ps.config.MaxRetryCount,
+	}
+}
+
+func NewPlanService(log *log.Logger, config *config.AppConfig, redisClient *redis.Client) *PlanService {
+	return &PlanService{
+		log:         log,
+		config:      config,
+		redisClient: redisClient,
+		plans:       make(map[string]cachedPlan),
+	}
+}
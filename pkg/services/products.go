@@ -1,113 +1,172 @@
 package services
 
 import (
-	"fmt"
+	"errors"
 	"log"
+	"time"
 	"warehouse-system/config"
 	e "warehouse-system/errors"
 	"warehouse-system/pkg/models"
+	"warehouse-system/pkg/queries"
+	"warehouse-system/pkg/queue"
 	"warehouse-system/pkg/redis"
 )
 
+// CacheStatus tells how a report was served, it is sent in the X-Cache header.
+type CacheStatus string
+
+const (
+	CacheHit   CacheStatus = "HIT"
+	CacheStale CacheStatus = "STALE"
+	CacheMiss  CacheStatus = "MISS"
+)
+
 type ProductService struct {
 	log         *log.Logger
 	config      *config.AppConfig
 	redisClient *redis.Client
+	// misses collapses concurrent cache misses of the same report into one queue request
+	misses *singleFlight
 }
 
-func (ps *ProductService) GetBoughtProducts(token, uid string) ([]models.BoughtProductsQuantity, error) {
-	boughtProductsQuantity, err := ps.redisClient.GetBoughtProductsCache()
+// GetReport returns a report of the tenant from cache. A stale report is returned immediately
+// and recomputed in background. If the cache is empty, it puts the request to a queue
+// and waits for the query worker to compute the report.
+func (ps *ProductService) GetReport(query *queries.Query, tenant string, params queries.Params,
+	token, uid string, trace queue.TraceContext) (interface{}, CacheStatus, error) {
+	if token == ps.config.SystemToken {
+		return nil, CacheMiss, e.BadRequestError{Message: "token is reserved"}
+	}
+	if tenant == "" {
+		return nil, CacheMiss, e.BadRequestError{Message: "token has no tenant"}
+	}
+
+	cacheKey := query.CacheKey(tenant, params)
+	fields, err := ps.redisClient.GetCache(cacheKey)
 	if err != nil {
-		return nil, err
+		return nil, CacheMiss, err
 	}
 
-	if boughtProductsQuantity != nil {
-		ps.log.Println("'products:bought' cache is found.")
-		return boughtProductsQuantity, nil
+	if len(fields) != 0 {
+		report, err := query.Decode(fields)
+		if err != nil {
+			return nil, CacheMiss, err
+		}
+		if !query.IsStale(fields, time.Now()) {
+			ps.log.Printf("'%s' cache is found.\n", cacheKey)
+			return report, CacheHit, nil
+		}
+		ps.log.Printf("'%s' cache is stale.\n", cacheKey)
+		ps.refreshReport(query, tenant, params, token, trace)
+		return report, CacheStale, nil
 	}
 
 	// if cache is empty, put request to a queue
-	ps.log.Println("'products:bought' cache is empty.")
-
-	request := fmt.Sprintf("%s:%s:products:bought", token, uid)
-	if err := ps.redisClient.PutRequestToQueue(request); err != nil {
-		ps.log.Printf("Unable to put request to queue: %s\n", err)
-		return nil, err
+	ps.log.Printf("'%s' cache is empty.\n", cacheKey)
+
+	report, follower, err := ps.misses.do(cacheKey, func() (interface{}, error) {
+		return ps.requestReport(query, tenant, params, token, uid, trace)
+	})
+	var maxRetryErr e.MaxRetryCountExceededError
+	if follower && errors.As(err, &maxRetryErr) {
+		// the request of another token was throttled, it doesn't apply to this one
+		report, err = ps.requestReport(query, tenant, params, token, uid, trace)
 	}
-	ps.log.Println("Request is put to queue successfully.")
+	return report, CacheMiss, err
+}
 
-	topic := fmt.Sprintf("%s:%s", token, uid)
-	message, err := ps.redisClient.SubscribeForResult(topic, ps.config.SubscribeTimeout)
+// refreshReport puts a request to recompute the stale report to a queue without waiting
+// for the result. Only one refresh of the report is requested at a time.
+func (ps *ProductService) refreshReport(query *queries.Query, tenant string, params queries.Params,
+	token string, trace queue.TraceContext) {
+	cacheKey := query.CacheKey(tenant, params)
+	refreshTimeout := time.Duration(ps.config.InflightTimeout) * time.Second
+	marked, err := ps.redisClient.MarkRefreshing(cacheKey, refreshTimeout)
 	if err != nil {
-		ps.log.Printf("Subscribing for result failed: %s\n", err)
-		return nil, err
+		ps.log.Printf("Unable to mark '%s' refreshing: %s\n", cacheKey, err)
+		return
 	}
-	ps.log.Printf("Query worker has processed the request: %s\n", message)
-
-	if message == "internal_err" {
-		return nil, e.ProcessQueryFailedError{Message: "failed to process query"}
+	if !marked {
+		return
 	}
 
-	if message == "max_retry_count" {
-		return nil, e.MaxRetryCountExceededError{}
+	message := queue.NewMessage(token, tenant, queue.NewMessageID(), query.Name, params,
+		time.Now().Add(refreshTimeout), trace)
+	request, err := message.Encode()
+	if err != nil {
+		ps.log.Printf("Unable to encode refresh request: %s\n", err)
+		return
 	}
-
-	if message == "success" {
-		boughtProductsQuantity, err = ps.redisClient.GetBoughtProductsCache()
-		if err != nil {
-			return nil, err
-		}
-		return boughtProductsQuantity, nil
+	if err := ps.redisClient.PutRequestToQueue(request); err != nil {
+		ps.log.Printf("Unable to put refresh request to queue: %s\n", err)
+		return
 	}
-
-	return nil, nil
+	ps.log.Printf("'%s' refresh request is put to queue.\n", cacheKey)
 }
 
-func (ps *ProductService) GetBoughtItems(token, uid string) ([]models.BoughtItemsQuantity, error) {
-	boughtItemsQuantity, err := ps.redisClient.GetBoughtItemsCache()
+// requestReport puts the report request to a queue and waits for the query worker to compute it.
+func (ps *ProductService) requestReport(query *queries.Query, tenant string, params queries.Params,
+	token, uid string, trace queue.TraceContext) (interface{}, error) {
+	cacheKey := query.CacheKey(tenant, params)
+
+	// nobody waits for the result after subscribe timeout
+	deadline := time.Now().Add(time.Duration(ps.config.SubscribeTimeout) * time.Second)
+	message := queue.NewMessage(token, tenant, uid, query.Name, params, deadline, trace)
+	request, err := message.Encode()
 	if err != nil {
 		return nil, err
 	}
 
-	if boughtItemsQuantity != nil {
-		ps.log.Println("'items:bought' cache is found.")
-		return boughtItemsQuantity, nil
+	subscription, err := ps.redisClient.SubscribeForResult(message.ReplyTo)
+	if err != nil {
+		ps.log.Printf("Subscribing for result failed: %s\n", err)
+		return nil, err
 	}
+	defer subscription.Close()
 
-	// if cache is empty, put request to a queue
-	ps.log.Println("'items:bought' cache is empty.")
-
-	request := fmt.Sprintf("%s:%s:items:bought", token, uid)
 	if err := ps.redisClient.PutRequestToQueue(request); err != nil {
 		ps.log.Printf("Unable to put request to queue: %s\n", err)
 		return nil, err
 	}
 	ps.log.Println("Request is put to queue successfully.")
 
-	topic := fmt.Sprintf("%s:%s", token, uid)
-	message, err := ps.redisClient.SubscribeForResult(topic, ps.config.SubscribeTimeout)
+	published, err := subscription.Wait(ps.config.SubscribeTimeout)
 	if err != nil {
+		ps.log.Printf("Subscribing for result failed: %s\n", err)
 		return nil, err
 	}
-	ps.log.Printf("Query worker has processed the request: %s\n", message)
-
-	if message == "internal_err" {
-		return nil, e.ProcessQueryFailedError{Message: "failed to process query"}
+	result, err := queue.DecodeResult(published)
+	if err != nil {
+		return nil, err
 	}
+	ps.log.Printf("Query worker has processed the request: %s\n", result.Status)
 
-	if message == "max_retry_count" {
+	switch result.Status {
+	case "internal_err":
+		return nil, e.ProcessQueryFailedError{Message: "failed to process query"}
+	case "max_retry_count":
 		return nil, e.MaxRetryCountExceededError{}
-	}
-
-	if message == "success" {
-		boughtItemsQuantity, err = ps.redisClient.GetBoughtItemsCache()
+	case "unknown_query":
+		return nil, e.UnknownQueryError{Name: query.Name}
+	case "success":
+		if len(result.Payload) != 0 {
+			return query.DecodePayload(result.Payload)
+		}
+		// workers of the previous release don't publish the payload
+		fields, err := ps.redisClient.GetCache(cacheKey)
 		if err != nil {
 			return nil, err
 		}
-		return boughtItemsQuantity, nil
+		if len(fields) == 0 {
+			return nil, e.ProcessQueryFailedError{Message: "report cache expired before it was read"}
+		}
+		return query.Decode(fields)
 	}
+	return nil, e.ProcessQueryFailedError{Message: "unexpected query result " + result.Status}
+}
 
-	return nil, nil
+func (ps *ProductService) GetReportRefreshes() ([]models.ReportRefresh, error) {
+	return ps.redisClient.GetReportRefreshes()
 }
 
 func NewProductService(log *log.Logger, config *config.AppConfig, redisClient *redis.Client) *ProductService {
@@ -116,5 +175,6 @@ func NewProductService(log *log.Logger, config *config.AppConfig, redisClient *r
 		log:         log,
 		config:      config,
 		redisClient: redisClient,
+		misses:      newSingleFlight(),
 	}
 }
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"log"
+	"time"
+	"warehouse-system/config"
+	e "warehouse-system/errors"
+	"warehouse-system/pkg/ratelimit"
+)
+
+// RateLimitService limits requests of a token at the web server edge, before they reach
+// the cache or the queue. Limits are read from the quota plan of the token.
+type RateLimitService struct {
+	log          *log.Logger
+	config       *config.AppConfig
+	plans        *PlanService
+	limiter      *ratelimit.Limiter
+	queryLimiter *ratelimit.Limiter
+	// authLimiter counts failed authentications of client addresses
+	authLimiter *ratelimit.Limiter
+	quotas      *ratelimit.Quotas
+}
+
+// Allow counts the request of the token, it returns RateLimitExceededError if the token
+// has exceeded its rate limit or daily quota.
+func (rs *RateLimitService) Allow(token string) (ratelimit.Decision, error) {
+	plan, err := rs.plans.GetPlan(token)
+	if err != nil {
+		rs.log.Printf("Unable to get plan: %s\n", err)
+		return ratelimit.Decision{}, err
+	}
+
+	decision, err := rs.limiter.Allow(token, plan.RequestLimit())
+	if err != nil {
+		rs.log.Printf("Unable to check rate limit: %s\n", err)
+		return decision, err
+	}
+	if !decision.Allowed {
+		return decision, e.RateLimitExceededError{RetryAfter: decision.RetryAfter}
+	}
+
+	quota, err := rs.quotas.Take(token, plan.DailyQuota)
+	if err != nil {
+		rs.log.Printf("Unable to check daily quota: %s\n", err)
+		return quota, err
+	}
+	if !quota.Allowed {
+		return quota, e.RateLimitExceededError{RetryAfter: quota.RetryAfter}
+	}
+	return decision, nil
+}
+
+// QueryLimitState returns the state of the limit of queries of the token run by query workers.
+func (rs *RateLimitService) QueryLimitState(token string) (ratelimit.Decision, error) {
+	plan, err := rs.plans.GetPlan(token)
+	if err != nil {
+		return ratelimit.Decision{}, err
+	}
+	return rs.queryLimiter.Peek(token, plan.QueryLimit())
+}
+
+// CheckAuthFailures returns RateLimitExceededError if the client address has exceeded the limit
+// of failed authentications. It is checked before credentials are looked up.
+func (rs *RateLimitService) CheckAuthFailures(address string) (ratelimit.Decision, error) {
+	decision, err := rs.authLimiter.Peek(address, rs.authFailureLimit())
+	if err != nil {
+		rs.log.Printf("Unable to check auth failure limit: %s\n", err)
+		return decision, err
+	}
+	if !decision.Allowed {
+		return decision, e.RateLimitExceededError{RetryAfter: decision.RetryAfter}
+	}
+	return decision, nil
+}
+
+// CountAuthFailure counts a failed authentication of the client address.
+func (rs *RateLimitService) CountAuthFailure(address string) {
+	if _, err := rs.authLimiter.Allow(address, rs.authFailureLimit()); err != nil {
+		rs.log.Printf("Unable to count auth failure: %s\n", err)
+	}
+}
+
+func (rs *RateLimitService) authFailureLimit() ratelimit.Limit {
+	return ratelimit.Limit{
+		Requests: rs.config.AuthFailureLimit,
+		Period:   time.Duration(rs.config.AuthFailurePeriod) * time.Second,
+	}
+}
+
+func NewRateLimitService(log *log.Logger, config *config.AppConfig, plans *PlanService,
+	limiter, queryLimiter, authLimiter *ratelimit.Limiter, quotas *ratelimit.Quotas) *RateLimitService {
+	return &RateLimitService{
+		log:          log,
+		config:       config,
+		plans:        plans,
+		limiter:      limiter,
+		queryLimiter: queryLimiter,
+		authLimiter:  authLimiter,
+		quotas:       quotas,
+	}
+}
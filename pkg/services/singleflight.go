@@ -0,0 +1,55 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+)
+
+type flight struct {
+	wg     sync.WaitGroup
+	result interface{}
+	err    error
+}
+
+// singleFlight collapses concurrent calls with the same key into one.
+type singleFlight struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// do runs fn once for all concurrent callers with the key. The follower flag is set
+// for callers that got the result computed by another caller.
+func (sf *singleFlight) do(key string, fn func() (interface{}, error)) (result interface{}, follower bool, err error) {
+	sf.mu.Lock()
+	if f, ok := sf.flights[key]; ok {
+		sf.mu.Unlock()
+		f.wg.Wait()
+		return f.result, true, f.err
+	}
+	f := &flight{}
+	f.wg.Add(1)
+	sf.flights[key] = f
+	sf.mu.Unlock()
+
+	// the flight is finished even if fn panics, so followers don't wait forever
+	returned := false
+	defer func() {
+		if !returned {
+			f.err = fmt.Errorf("call of %s panicked", key)
+		}
+		f.wg.Done()
+		sf.mu.Lock()
+		delete(sf.flights, key)
+		sf.mu.Unlock()
+	}()
+
+	f.result, f.err = fn()
+	returned = true
+	return f.result, false, f.err
+}
+
+func newSingleFlight() *singleFlight {
+	return &singleFlight{
+		flights: make(map[string]*flight),
+	}
+}
@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// BackoffStrategy returns a delay before the next retry of a throttled request.
+type BackoffStrategy func(retryCount int) time.Duration
+
+// maxBackoffExponent caps exponential delays at about 18 hours.
+const maxBackoffExponent = 16
+
+var (
+	jitterMu  sync.Mutex
+	jitterRnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+func FibonacciBackoff(retryCount int) time.Duration {
+	return time.Duration(GetFibonacciNumber(retryCount)) * time.Second
+}
+
+func ExponentialBackoff(retryCount int) time.Duration {
+	if retryCount > maxBackoffExponent {
+		retryCount = maxBackoffExponent
+	}
+	return time.Duration(1<<uint(retryCount)) * time.Second
+}
+
+// JitteredBackoff picks a random delay up to the exponential one,
+// so throttled requests of different clients don't retry at the same moment.
+func JitteredBackoff(retryCount int) time.Duration {
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+	return time.Duration(jitterRnd.Int63n(int64(ExponentialBackoff(retryCount)) + 1))
+}
+
+func GetBackoffStrategy(name string) (BackoffStrategy, error) {
+	switch name {
+	case "fibonacci":
+		return FibonacciBackoff, nil
+	case "exponential":
+		return ExponentialBackoff, nil
+	case "jittered":
+		return JitteredBackoff, nil
+	}
+	return nil, fmt.Errorf("unknown backoff strategy %s", name)
+}